@@ -0,0 +1,113 @@
+package simplewayback
+
+import (
+	"context"
+	"encoding/json"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// replayOriginal matches the original URL of a replay URL, e.g. /web/20060102150405/http://example.org/
+var replayOriginal = regexp.MustCompile(`/\d{14}(?:[a-z]{2}_)?/(.+)$`)
+
+// Availability is the closest snapshot of a URL reported by the Wayback Availability API. The
+// embedded CDXResult only holds Original, Timestamp and StatusCode; its Data fetches the
+// snapshot like the results of Perform. If Available is false, no snapshot exists and Data is
+// nil.
+type Availability struct {
+	CDXResult
+	// URL is the replay URL of the snapshot
+	URL string `json:"url"`
+	// Available reports whether the archive holds a snapshot of the URL
+	Available bool `json:"available"`
+}
+
+// availabilityResponse is the JSON response of the Wayback Availability API
+type availabilityResponse struct {
+	URL               string `json:"url"`
+	ArchivedSnapshots struct {
+		Closest *struct {
+			Status    string `json:"status"`
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// SetAvailabilityEndpoint sets the URL of the Wayback Availability API
+func (cdx *CDXAPI) SetAvailabilityEndpoint(endpoint string) error {
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	cdx.availEndpoint = endpoint
+	return nil
+}
+
+// AvailabilityEndpoint getter
+func (cdx *CDXAPI) AvailabilityEndpoint() string {
+	if cdx == nil || cdx.availEndpoint == "" {
+		return availableURL
+	}
+	return cdx.availEndpoint
+}
+
+// ResetAvailabilityEndpoint resets the Availability API endpoint (default: https://archive.org/wayback/available)
+func (cdx *CDXAPI) ResetAvailabilityEndpoint() {
+	cdx.availEndpoint = ""
+}
+
+// Available asks the Wayback Availability API for the snapshot of the URL of cdx closest to
+// timestamp. A zero timestamp requests the most recent snapshot. Only the URL of cdx is
+// used, all other search parameters are ignored.
+func (cdx *CDXAPI) Available(timestamp time.Time) (*Availability, error) {
+	return cdx.AvailableContext(context.Background(), timestamp)
+}
+
+// AvailableContext is like Available, but the request and the Data of the returned
+// snapshot are bound to ctx
+func (cdx *CDXAPI) AvailableContext(ctx context.Context, timestamp time.Time) (*Availability, error) {
+	if cdx.URL() == "" {
+		return nil, ErrorInvalidURL
+	}
+	params := neturl.Values{}
+	params.Set("url", cdx.URL())
+	if !timestamp.IsZero() {
+		params.Set("timestamp", timestamp.Format("20060102150405"))
+	}
+	endpoint := cdx.AvailabilityEndpoint()
+	if strings.Contains(endpoint, "?") {
+		endpoint += "&"
+	} else {
+		endpoint += "?"
+	}
+	qry, err := cdx.get(ctx, endpoint+params.Encode(), false)
+	if err != nil {
+		return nil, err
+	}
+	defer qry.Close()
+	var resp availabilityResponse
+	if err := json.NewDecoder(qry).Decode(&resp); err != nil {
+		return nil, ErrorMalformedResult
+	}
+	avail := &Availability{CDXResult: CDXResult{Original: cdx.URL(), cdx: cdx}}
+	closest := resp.ArchivedSnapshots.Closest
+	if closest == nil || !closest.Available {
+		return avail, nil
+	}
+	if avail.Timestamp, err = time.Parse("20060102150405", closest.Timestamp); err != nil {
+		return nil, ErrorMalformedResult
+	}
+	if avail.StatusCode, err = parseNumber(closest.Status); err != nil {
+		return nil, ErrorMalformedResult
+	}
+	if match := replayOriginal.FindStringSubmatch(closest.URL); match != nil {
+		avail.Original = match[1]
+	}
+	avail.URL = closest.URL
+	avail.Available = true
+	avail.Data = avail.DataContext(ctx)
+	return avail, nil
+}
@@ -0,0 +1,105 @@
+package simplewayback
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCDXAPI_SetAvailabilityEndpoint(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"ErrorInvalidEndpoint", "archive.org/wayback/available", availableURL, true},
+		{"Valid endpoint", "http://localhost:8080/wayback/available", "http://localhost:8080/wayback/available", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetAvailabilityEndpoint(tt.endpoint); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetAvailabilityEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := cdx.AvailabilityEndpoint(); got != tt.want {
+				t.Errorf("CDXAPI.AvailabilityEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	cdx.ResetAvailabilityEndpoint()
+	if got := cdx.AvailabilityEndpoint(); got != availableURL {
+		t.Errorf("CDXAPI.ResetAvailabilityEndpoint() = %v, want %v", got, availableURL)
+	}
+}
+
+func TestCDXAPI_Available(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") != "" {
+			http.Error(w, "unexpected cookie", http.StatusBadRequest)
+			return
+		}
+		switch {
+		case r.URL.Path == "/wayback/available" && r.URL.Query().Get("url") == "example.org":
+			w.Write([]byte(`{"url":"example.org","archived_snapshots":{}}`))
+		case r.URL.Path == "/wayback/available" && r.URL.Query().Get("url") == "malformed.org":
+			w.Write([]byte(`{"url":`))
+		case r.URL.Path == "/wayback/available" && r.URL.Query().Get("timestamp") == "20060102150405":
+			w.Write([]byte(`{"url":"archive.org","archived_snapshots":{"closest":{"status":"200","available":true,` +
+				`"url":"` + srv.URL + `/web/20060102150405/http://archive.org/","timestamp":"20060102150405"}}}`))
+		case r.URL.Path == "/web/20060102150405/http://archive.org/":
+			w.Write([]byte("snapshot"))
+		default:
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name          string
+		url           string
+		query         string
+		timestamp     time.Time
+		wantAvailable bool
+		wantBody      string
+		wantErr       error
+	}{
+		{"Available", "archive.org", "", tm, true, "snapshot", nil},
+		{"Not available", "example.org", "", tm, false, "", nil},
+		{"Endpoint with query", "example.org", "?coll=test", tm, false, "", nil},
+		{"ErrorMalformedResult", "malformed.org", "", tm, false, "", ErrorMalformedResult},
+		{"ErrorRateLimited", "archive.org", "", time.Time{}, false, "", ErrorRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx, _ := NewCDXAPI(tt.url)
+			cdx.SetAPIKey("SECRET")
+			cdx.SetAvailabilityEndpoint(srv.URL + "/wayback/available" + tt.query)
+			cdx.SetReplayEndpoint(srv.URL + "/web")
+			got, err := cdx.Available(tt.timestamp)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("CDXAPI.Available() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Available != tt.wantAvailable {
+				t.Fatalf("CDXAPI.Available() = %+v, want available %v", got, tt.wantAvailable)
+			}
+			if !got.Available {
+				return
+			}
+			if got.Original != "http://archive.org/" || !got.Timestamp.Equal(tm) || got.StatusCode != 200 {
+				t.Errorf("CDXAPI.Available() = %+v", got)
+			}
+			body, err := ioutil.ReadAll(got.Data)
+			if err != nil || string(body) != tt.wantBody {
+				t.Errorf("Availability.Data = %q, %v, want %q", body, err, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package simplewayback
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"hash"
+	"io"
+	"strings"
+)
+
+// digestVerifier hashes a snapshot payload while it is read and compares the digest at EOF
+type digestVerifier struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+	url      string
+	err      error
+}
+
+// NewDigestVerifier wraps r, computing the base32 encoded SHA-1 digest of everything read
+// from it. Once r is exhausted, the digest is compared against digest (as found in
+// CDXResult.Digest, an optional "sha1:" prefix is ignored). On mismatch, Read returns a
+// *DigestMismatchError instead of io.EOF.
+func NewDigestVerifier(r io.Reader, digest string) io.Reader {
+	return &digestVerifier{r: r, hash: sha1.New(), expected: normalizeDigest(digest)}
+}
+
+// VerifiedData returns a reader for the raw archived payload of r (ReplayModeIdentity),
+// whose SHA-1 digest is verified against r.Digest while streaming. A *DigestMismatchError
+// is returned at EOF if the payload does not match.
+func (r CDXResult) VerifiedData(ctx context.Context) io.ReadCloser {
+	data := r.reader(ctx, ReplayModeIdentity)
+	return &digestVerifier{r: data, hash: sha1.New(), expected: normalizeDigest(r.Digest), url: data.url}
+}
+
+// Read implements the Reader interface for digestVerifier
+func (v *digestVerifier) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	if v.expected == "" || v.expected == "-" {
+		v.err = ErrorMissingDigest
+		return 0, v.err
+	}
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		actual := base32.StdEncoding.EncodeToString(v.hash.Sum(nil))
+		if actual != v.expected {
+			err = &DigestMismatchError{URL: v.url, Expected: v.expected, Actual: actual}
+		}
+	}
+	if err != nil {
+		v.err = err
+	}
+	return n, err
+}
+
+// Close closes the underlying reader, if it implements io.Closer
+func (v *digestVerifier) Close() error {
+	if closer, ok := v.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// normalizeDigest strips the algorithm prefix of digest and converts it to upper case
+func normalizeDigest(digest string) string {
+	digest = strings.TrimSpace(digest)
+	if i := strings.IndexByte(digest, ':'); i >= 0 && strings.EqualFold(digest[:i], "sha1") {
+		digest = digest[i+1:]
+	}
+	return strings.ToUpper(digest)
+}
@@ -0,0 +1,95 @@
+package simplewayback
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDigest returns the base32 encoded SHA-1 digest of payload
+func testDigest(payload string) string {
+	sum := sha1.Sum([]byte(payload))
+	return base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestNewDigestVerifier(t *testing.T) {
+	digest := testDigest("payload")
+	tests := []struct {
+		name    string
+		payload string
+		digest  string
+		wantErr error
+	}{
+		{"Match", "payload", digest, nil},
+		{"Match with prefix", "payload", "sha1:" + digest, nil},
+		{"Match lower case", "payload", strings.ToLower(digest), nil},
+		{"ErrorDigestMismatch", "truncated", digest, ErrorDigestMismatch},
+		{"ErrorMissingDigest", "payload", "-", ErrorMissingDigest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ioutil.ReadAll(NewDigestVerifier(strings.NewReader(tt.payload), tt.digest))
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("NewDigestVerifier().Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(got) != tt.payload {
+				t.Errorf("NewDigestVerifier().Read() = %q, want %q", got, tt.payload)
+			}
+		})
+	}
+}
+
+func TestDigestMismatchError_Error(t *testing.T) {
+	err := &DigestMismatchError{URL: "http://web.archive.org/web/2006id_/x", Expected: "AAAA", Actual: "BBBB"}
+	want := "simplewayback: Payload digest mismatch (expected AAAA, got BBBB): http://web.archive.org/web/2006id_/x"
+	if err.Error() != want {
+		t.Errorf("DigestMismatchError.Error() = %v, want %v", err.Error(), want)
+	}
+}
+
+func TestCDXResult_VerifiedData(t *testing.T) {
+	var acceptEncoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/web/20060102150405id_/http://archive.org/" {
+			http.NotFound(w, r)
+			return
+		}
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name    string
+		digest  string
+		wantErr error
+	}{
+		{"Match", testDigest("payload"), nil},
+		{"ErrorDigestMismatch", testDigest("other"), ErrorDigestMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := CDXResult{Original: "http://archive.org/", Timestamp: tm, Digest: tt.digest, cdx: cdx}
+			_, err := ioutil.ReadAll(result.VerifiedData(context.Background()))
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("CDXResult.VerifiedData().Read() error = %v, want %v", err, tt.wantErr)
+			}
+			var mismatch *DigestMismatchError
+			if errors.As(err, &mismatch) && !strings.HasPrefix(mismatch.URL, srv.URL) {
+				t.Errorf("DigestMismatchError.URL = %v", mismatch.URL)
+			}
+			if acceptEncoding != "identity" {
+				t.Errorf("CDXResult.VerifiedData() sent Accept-Encoding %q, want identity", acceptEncoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package simplewayback
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxErrorBody is the number of bytes of an error response read to build an APIError
+const maxErrorBody = 4096
+
+// maxErrorExcerpt is the number of bytes of an error response kept in APIError.Body
+const maxErrorExcerpt = 512
+
+var (
+	javaExceptionPrefix = regexp.MustCompile(`^[\w.$]+(Exception|Error): *`)
+	htmlTitle           = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// APIError is returned if the archive answers a request with a non-success status code. It can
+// be classified using errors.Is with ErrorBadResponse, ErrorRateLimited, ErrorBlocked,
+// ErrorNotFound and ErrorServerError.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// URL is the requested URL
+	URL string
+	// Body is an excerpt of the response body
+	Body string
+	// Message is the error message reported by the archive, if any
+	Message string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("simplewayback: Bad Response from Wayback Machine API (%d %s)", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is classifies the error for errors.Is
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrorBadResponse:
+		return true
+	case ErrorRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrorBlocked:
+		return e.StatusCode == http.StatusForbidden || strings.Contains(strings.ToLower(e.Message), "blocked")
+	case ErrorNotFound:
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	case ErrorServerError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// newAPIError builds an APIError from resp and closes its body
+func newAPIError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	excerpt := body
+	if len(excerpt) > maxErrorExcerpt {
+		excerpt = excerpt[:maxErrorExcerpt]
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		URL:        resp.Request.URL.String(),
+		Body:       string(excerpt),
+		Message:    errorMessage(body),
+	}
+}
+
+// errorMessage extracts the error message from the body of an error response. The CDX
+// server reports errors as plain text Java exceptions, e.g.
+// "org.archive.wayback.exception.RobotAccessControlException: Blocked By Robots", other
+// servers use json objects or html pages.
+func errorMessage(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if strings.HasPrefix(text, "{") {
+		var obj struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &obj) == nil {
+			if obj.Message != "" {
+				return obj.Message
+			}
+			return obj.Error
+		}
+	}
+	if strings.HasPrefix(text, "<") {
+		if match := htmlTitle.FindStringSubmatch(text); match != nil {
+			return strings.TrimSpace(match[1])
+		}
+		return ""
+	}
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	return javaExceptionPrefix.ReplaceAllString(text, "")
+}
+
+// DigestMismatchError is returned by verifying readers if the SHA-1 digest of a downloaded
+// payload does not match the digest reported by the CDX API. It matches ErrorDigestMismatch
+// using errors.Is.
+type DigestMismatchError struct {
+	// URL is the snapshot URL, if known
+	URL string
+	// Expected is the base32 encoded digest reported by the CDX API
+	Expected string
+	// Actual is the base32 encoded digest of the downloaded payload
+	Actual string
+}
+
+// Error implements the error interface
+func (e *DigestMismatchError) Error() string {
+	msg := fmt.Sprintf("simplewayback: Payload digest mismatch (expected %s, got %s)", e.Expected, e.Actual)
+	if e.URL != "" {
+		msg += ": " + e.URL
+	}
+	return msg
+}
+
+// Is classifies the error for errors.Is
+func (e *DigestMismatchError) Is(target error) bool {
+	return target == ErrorDigestMismatch
+}
+
+// RedirectError is returned by snapshot requests if the archive redirects to a location that
+// is not followed, e.g. to another capture while following redirects is disabled or to a
+// location outside of the replay endpoint. It matches ErrorRedirect using errors.Is.
+type RedirectError struct {
+	// StatusCode is the HTTP status code of the redirect
+	StatusCode int
+	// URL is the requested URL
+	URL string
+	// Location is the absolute redirect target
+	Location string
+	// Timestamp is the capture time of the redirect target, if it is a replay URL
+	Timestamp time.Time
+}
+
+// Error implements the error interface
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("simplewayback: Snapshot redirects to another location (%d %s): %s", e.StatusCode, http.StatusText(e.StatusCode), e.Location)
+}
+
+// Is classifies the error for errors.Is
+func (e *RedirectError) Is(target error) bool {
+	return target == ErrorRedirect
+}
+
+// SaveError is returned if Save Page Now rejects or fails a capture. It matches ErrorSaveFailed
+// using errors.Is.
+type SaveError struct {
+	// JobID is the ID of the capture job, if one has been started
+	JobID string
+	// StatusExt is the machine readable error code, e.g. "error:invalid-url-syntax"
+	StatusExt string
+	// Message is the error message reported by Save Page Now
+	Message string
+}
+
+// Error implements the error interface
+func (e *SaveError) Error() string {
+	msg := "simplewayback: Save Page Now capture failed"
+	if e.StatusExt != "" {
+		msg += " (" + e.StatusExt + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is classifies the error for errors.Is
+func (e *SaveError) Is(target error) bool {
+	return target == ErrorSaveFailed
+}
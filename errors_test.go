@@ -0,0 +1,103 @@
+package simplewayback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *APIError
+		want string
+	}{
+		{"Without message", &APIError{StatusCode: 503}, "simplewayback: Bad Response from Wayback Machine API (503 Service Unavailable)"},
+		{"With message", &APIError{StatusCode: 403, Message: "Blocked By Robots"}, "simplewayback: Bad Response from Wayback Machine API (403 Forbidden): Blocked By Robots"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("APIError.Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIError_Is(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *APIError
+		target error
+		want   bool
+	}{
+		{"ErrorBadResponse", &APIError{StatusCode: 400}, ErrorBadResponse, true},
+		{"ErrorRateLimited", &APIError{StatusCode: 429}, ErrorRateLimited, true},
+		{"ErrorBlocked status", &APIError{StatusCode: 403}, ErrorBlocked, true},
+		{"ErrorBlocked message", &APIError{StatusCode: 400, Message: "Blocked Site Error"}, ErrorBlocked, true},
+		{"ErrorNotFound", &APIError{StatusCode: 404}, ErrorNotFound, true},
+		{"ErrorServerError", &APIError{StatusCode: 502}, ErrorServerError, true},
+		{"Not ErrorServerError", &APIError{StatusCode: 404}, ErrorServerError, false},
+		{"Unrelated", &APIError{StatusCode: 404}, ErrorInvalidURL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_errorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"Java exception", "org.archive.wayback.exception.RobotAccessControlException: Blocked By Robots\n\tat foo\n", "Blocked By Robots"},
+		{"Plain text", "  Invalid filter\n", "Invalid filter"},
+		{"JSON message", `{"message":"Rate limit exceeded"}`, "Rate limit exceeded"},
+		{"JSON error", `{"error":"invalid url"}`, "invalid url"},
+		{"HTML", "<html><head><title> Wayback Machine </title></head></html>", "Wayback Machine"},
+		{"HTML without title", "<html></html>", ""},
+		{"Empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorMessage([]byte(tt.body)); got != tt.want {
+				t.Errorf("errorMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_PerformAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/web/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("org.archive.wayback.exception.RobotAccessControlException: Blocked By Robots\n" + strings.Repeat("x", 1000)))
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL)
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	_, err := cdx.Perform()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrorBlocked) {
+		t.Fatalf("CDXAPI.Perform() error = %v, want ErrorBlocked", err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden || apiErr.Message != "Blocked By Robots" || len(apiErr.Body) != maxErrorExcerpt || !strings.HasPrefix(apiErr.URL, srv.URL) {
+		t.Errorf("CDXAPI.Perform() error = %#v", apiErr)
+	}
+	_, err = CDXResult{Original: "http://archive.org/", cdx: cdx}.DataContext(context.Background()).Read(make([]byte, 10))
+	if !errors.Is(err, ErrorNotFound) {
+		t.Errorf("CDXResult.DataContext().Read() error = %v, want ErrorNotFound", err)
+	}
+}
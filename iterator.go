@@ -0,0 +1,310 @@
+package simplewayback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// CDXIterator streams the results of a CDX query. Rows are decoded from the response one at
+// a time, so memory usage stays constant regardless of the size of the result set:
+//
+//	it, err := cdx.Iterate()
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		result := it.Result()
+//		...
+//	}
+//	return it.Err()
+type CDXIterator struct {
+	cdx *CDXAPI
+	ctx context.Context
+	qry *CDXRawQuery
+	dec rowDecoder
+	// advance prepares the parameters of the next query once a response has been consumed
+	// completely. It reports whether there is a further query to perform.
+	advance func() bool
+	result  CDXResult
+	err     error
+	done    bool
+}
+
+// Iterate queries the CDX API and returns an iterator over the results. Both OutputFormatJSON
+// and OutputFormatCDX responses are supported.
+func (cdx *CDXAPI) Iterate() (*CDXIterator, error) {
+	return cdx.IterateContext(context.Background())
+}
+
+// IterateContext is like Iterate, but the query is bound to ctx. The Data readers of the
+// yielded results inherit ctx as well.
+func (cdx *CDXAPI) IterateContext(ctx context.Context) (*CDXIterator, error) {
+	it := &CDXIterator{cdx: cdx, ctx: ctx}
+	if err := it.query(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// IterateAll walks the whole result set using resumption keys. The CDX API is queried
+// repeatedly with limit=batchSize and the last returned resumption key until no further
+// key is returned. This enables the resumption key mode of cdx and overwrites its limit.
+// An interrupted walk can be continued later by passing CDXIterator.ResumptionKey to
+// SetResumptionKey before calling IterateAll again.
+func (cdx *CDXAPI) IterateAll(batchSize int) (*CDXIterator, error) {
+	return cdx.IterateAllContext(context.Background(), batchSize)
+}
+
+// IterateAllContext is like IterateAll, but all queries are bound to ctx
+func (cdx *CDXAPI) IterateAllContext(ctx context.Context, batchSize int) (*CDXIterator, error) {
+	if err := cdx.SetResumptionKey(true, cdx.ResumptionKey()); err != nil {
+		return nil, err
+	}
+	if err := cdx.SetLimit(batchSize); err != nil {
+		return nil, err
+	}
+	it := &CDXIterator{cdx: cdx, ctx: ctx}
+	key := cdx.ResumptionKey()
+	it.advance = func() bool {
+		// the CDX API omits the resumption key on the last batch
+		if cdx.ResumptionKey() == key {
+			return false
+		}
+		key = cdx.ResumptionKey()
+		return true
+	}
+	if err := it.query(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// IteratePages walks all pages of the result set. The number of pages is determined using
+// NumPages first, then the pages 0..N-1 are queried one after another. This enables the
+// pagination mode of cdx and overwrites its current page.
+func (cdx *CDXAPI) IteratePages() (*CDXIterator, error) {
+	return cdx.IteratePagesContext(context.Background())
+}
+
+// IteratePagesContext is like IteratePages, but all queries are bound to ctx
+func (cdx *CDXAPI) IteratePagesContext(ctx context.Context) (*CDXIterator, error) {
+	pages, err := cdx.NumPagesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := cdx.SetPagination(true, 0); err != nil {
+		return nil, err
+	}
+	it := &CDXIterator{cdx: cdx, ctx: ctx}
+	if pages == 0 {
+		it.done = true
+		return it, nil
+	}
+	it.advance = func() bool {
+		if cdx.PaginationPage()+1 >= pages {
+			return false
+		}
+		return cdx.SetPagination(true, cdx.PaginationPage()+1) == nil
+	}
+	if err := it.query(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// query performs the next request to the CDX API and sets up the row decoder
+func (it *CDXIterator) query() error {
+	qry, err := it.cdx.RawPerformContext(it.ctx)
+	if err != nil {
+		return err
+	}
+	it.open(qry)
+	return nil
+}
+
+// open sets up the row decoder for a response of the CDX API
+func (it *CDXIterator) open(qry *CDXRawQuery) {
+	it.qry = qry
+	if it.cdx.OutputFormat() == int(OutputFormatJSON) {
+		it.dec = &jsonRowDecoder{dec: json.NewDecoder(qry), flds: it.cdx.responseFields()}
+	} else {
+		it.dec = newCDXLineDecoder(qry, it.cdx.responseFields())
+	}
+}
+
+// Next advances the iterator to the next result. It returns false once all results have
+// been consumed or an error occurred; check Err to distinguish between both cases.
+func (it *CDXIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	for {
+		row, isKey, err := it.dec.next()
+		if err == io.EOF && it.advance != nil && it.advance() {
+			// the response has been consumed completely, continue with the next one
+			it.qry.Close()
+			if err := it.query(); err != nil {
+				return it.finish(err)
+			}
+			continue
+		}
+		if err == io.EOF {
+			return it.finish(nil)
+		}
+		if err != nil {
+			return it.finish(err)
+		}
+		// resumption key stuff
+		if isKey {
+			if it.cdx.ResumptionKeyEnabled() {
+				it.cdx.params.Set("resumeKey", row[0])
+			}
+			continue
+		}
+		result, err := it.cdx.parseResult(it.ctx, row, it.dec.fields())
+		if err != nil {
+			return it.finish(err)
+		}
+		it.result = result
+		return true
+	}
+}
+
+// Result returns the current result. It is only valid after Next returned true.
+func (it *CDXIterator) Result() CDXResult {
+	return it.result
+}
+
+// Err returns the first error that occurred during iteration
+func (it *CDXIterator) Err() error {
+	return it.err
+}
+
+// ResumptionKey returns the resumption key of the last batch that has been consumed
+// completely. It is empty if resumption keys are not used.
+func (it *CDXIterator) ResumptionKey() string {
+	return it.cdx.ResumptionKey()
+}
+
+// Close stops the iteration and releases the underlying connection. It is safe to call
+// Close multiple times and after the iteration has finished.
+func (it *CDXIterator) Close() error {
+	it.finish(nil)
+	return nil
+}
+
+// finish ends the iteration, records err and releases the response body
+func (it *CDXIterator) finish(err error) bool {
+	if it.err == nil {
+		it.err = err
+	}
+	if !it.done {
+		it.done = true
+		if it.qry != nil {
+			it.qry.Close()
+		}
+	}
+	return false
+}
+
+// rowDecoder decodes a CDX API response row by row
+type rowDecoder interface {
+	// next returns the next row of the response or io.EOF. isKey reports whether the
+	// row holds the resumption key instead of a result.
+	next() (row []string, isKey bool, err error)
+	// fields returns the fields of the rows in order
+	fields() []field
+}
+
+// jsonRowDecoder decodes OutputFormatJSON responses: [["urlkey",...],[...],...,[],["key"]]
+type jsonRowDecoder struct {
+	dec     *json.Decoder
+	flds    []field
+	started bool
+	key     bool
+}
+
+func (d *jsonRowDecoder) next() ([]string, bool, error) {
+	if !d.started {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return nil, false, ErrorMalformedResult
+		}
+		// the first row holds the field names
+		if d.dec.More() {
+			var header []string
+			if err := d.dec.Decode(&header); err != nil {
+				return nil, false, err
+			}
+			d.flds = make([]field, len(header))
+			for i, name := range header {
+				d.flds[i] = fieldByName(name)
+			}
+		}
+		d.started = true
+	}
+	for d.dec.More() {
+		var row []string
+		if err := d.dec.Decode(&row); err != nil {
+			return nil, false, err
+		}
+		// an empty row separates the results from the resumption key
+		if len(row) == 0 {
+			d.key = true
+			continue
+		}
+		return row, d.key, nil
+	}
+	if _, err := d.dec.Token(); err != nil {
+		return nil, false, err
+	}
+	return nil, false, io.EOF
+}
+
+func (d *jsonRowDecoder) fields() []field {
+	return d.flds
+}
+
+// cdxLineDecoder decodes space delimited OutputFormatCDX responses. As there is no header
+// line, the fields have to be known in advance.
+type cdxLineDecoder struct {
+	scanner *bufio.Scanner
+	flds    []field
+	key     bool
+}
+
+func newCDXLineDecoder(r io.Reader, flds []field) *cdxLineDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &cdxLineDecoder{scanner: scanner, flds: flds}
+}
+
+func (d *cdxLineDecoder) fields() []field {
+	return d.flds
+}
+
+func (d *cdxLineDecoder) next() ([]string, bool, error) {
+	for d.scanner.Scan() {
+		line := strings.TrimSpace(d.scanner.Text())
+		// an empty line separates the results from the resumption key
+		if line == "" {
+			d.key = true
+			continue
+		}
+		if d.key {
+			return []string{line}, true, nil
+		}
+		return strings.Fields(line), false, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	return nil, false, io.EOF
+}
@@ -0,0 +1,335 @@
+package simplewayback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newCDXServer serves body as CDX API response
+func newCDXServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+}
+
+func TestCDXAPI_IterateContext(t *testing.T) {
+	header := `["urlkey","timestamp","original","mimetype","statuscode","digest","length"]`
+	row1 := `["org,archive)/","20060102150405","http://archive.org/","text/html","200","AAAA","123"]`
+	row2 := `["org,archive)/","20070102150405","http://archive.org/","text/html","-","BBBB","-"]`
+	line1 := "org,archive)/ 20060102150405 http://archive.org/ text/html 200 AAAA 123\n"
+	line2 := "org,archive)/ 20070102150405 http://archive.org/ text/html - BBBB -\n"
+	tests := []struct {
+		name       string
+		format     outputFormat
+		body       string
+		resumption bool
+		want       []string
+		wantKey    string
+		wantErr    bool
+	}{
+		{"JSON two results", OutputFormatJSON, "[" + header + "," + row1 + "," + row2 + "]", false, []string{"AAAA", "BBBB"}, "", false},
+		{"JSON no results", OutputFormatJSON, "[]", false, nil, "", false},
+		{"JSON empty body", OutputFormatJSON, "", false, nil, "", false},
+		{"JSON resumption key", OutputFormatJSON, "[" + header + "," + row1 + ",[],[\"org,archive)/ 20060102150405\"]]", true, []string{"AAAA"}, "org,archive)/ 20060102150405", false},
+		{"JSON ErrorMalformedResult object", OutputFormatJSON, `{"error":"x"}`, false, nil, "", true},
+		{"JSON ErrorMalformedResult row", OutputFormatJSON, "[" + header + `,["a","b"]]`, false, nil, "", true},
+		{"JSON truncated", OutputFormatJSON, "[" + header + "," + row1 + ",[\"org", false, []string{"AAAA"}, "", true},
+		{"CDX two results", OutputFormatCDX, line1 + line2, false, []string{"AAAA", "BBBB"}, "", false},
+		{"CDX no results", OutputFormatCDX, "", false, nil, "", false},
+		{"CDX resumption key", OutputFormatCDX, line1 + "\norg,archive)/ 20060102150405\n", true, []string{"AAAA"}, "org,archive)/ 20060102150405", false},
+		{"CDX ErrorMalformedResult", OutputFormatCDX, line1 + "a b\n", false, []string{"AAAA"}, "", true},
+		{"CDX invalid timestamp", OutputFormatCDX, "org,archive)/ 2006 http://archive.org/ text/html 200 AAAA 123\n", false, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newCDXServer(tt.body)
+			defer srv.Close()
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(srv.URL)
+			cdx.SetOutputFormat(tt.format)
+			if tt.resumption {
+				cdx.SetResumptionKey(true, "")
+			}
+			it, err := cdx.Iterate()
+			if err != nil {
+				t.Fatalf("CDXAPI.Iterate() error = %v", err)
+			}
+			defer it.Close()
+			var got []string
+			for it.Next() {
+				got = append(got, it.Result().Digest)
+			}
+			if (it.Err() != nil) != tt.wantErr {
+				t.Errorf("CDXIterator.Err() = %v, wantErr %v", it.Err(), tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("CDXIterator yielded %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("CDXIterator yielded %v, want %v", got, tt.want)
+				}
+			}
+			if cdx.ResumptionKey() != tt.wantKey {
+				t.Errorf("CDXAPI.ResumptionKey() = %v, want %v", cdx.ResumptionKey(), tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestParseCDXLine(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name    string
+		line    string
+		flds    []field
+		want    CDXResult
+		wantErr bool
+	}{
+		{"Default fields", "org,archive)/ 20060102150405 http://archive.org/ text/html 200 AAAA 123", nil,
+			CDXResult{URLKey: "org,archive)/", Timestamp: tm, Original: "http://archive.org/", MimeType: "text/html", StatusCode: 200, Digest: "AAAA", Length: 123}, false},
+		{"Unknown values", "org,archive)/ 20060102150405 http://archive.org/ warc/revisit - AAAA -", nil,
+			CDXResult{URLKey: "org,archive)/", Timestamp: tm, Original: "http://archive.org/", MimeType: "warc/revisit", Digest: "AAAA"}, false},
+		{"Custom fields", "20060102150405 http://archive.org/", []field{FieldTimestamp, FieldOriginal},
+			CDXResult{Timestamp: tm, Original: "http://archive.org/"}, false},
+		{"Extended fields", "org,archive)/ 20060102150405 http://archive.org/ text/html 301 AAAA http://archive.org/new - 512 3735928559 example.warc.gz",
+			[]field{FieldURLKey, FieldTimestamp, FieldOriginal, FieldMimetype, FieldStatuscode, FieldDigest, FieldRedirect, FieldRobotFlags, FieldLength, FieldOffset, FieldFilename},
+			CDXResult{URLKey: "org,archive)/", Timestamp: tm, Original: "http://archive.org/", MimeType: "text/html", StatusCode: 301, Digest: "AAAA",
+				Redirect: "http://archive.org/new", Length: 512, Offset: 3735928559, Filename: "example.warc.gz"}, false},
+		{"Invalid offset", "x 20060102150405", []field{FieldOffset, FieldTimestamp}, CDXResult{}, true},
+		{"ErrorMalformedResult", "20060102150405 http://archive.org/", nil, CDXResult{}, true},
+		{"Invalid statuscode", "org,archive)/ 20060102150405 http://archive.org/ text/html abc AAAA 123", nil, CDXResult{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCDXLine(tt.line, tt.flds...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCDXLine() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			got.Data = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCDXLine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXIterator_Close(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL + "/cdx/search/cdx")
+	it, err := cdx.Iterate()
+	if err != nil {
+		t.Fatalf("CDXAPI.Iterate() error = %v", err)
+	}
+	it.Close()
+	if it.Next() {
+		t.Errorf("CDXIterator.Next() = true after Close")
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("CDXIterator.Close() error = %v", err)
+	}
+}
+
+func TestCDXAPI_IterateAllContext(t *testing.T) {
+	batches := map[string]string{
+		"":   "a 20060102150405 http://a/ text/html 200 A 1\nb 20060102150405 http://b/ text/html 200 B 1\n\nk1\n",
+		"k1": "c 20060102150405 http://c/ text/html 200 C 1\nd 20060102150405 http://d/ text/html 200 D 1\n\nk2\n",
+		"k2": "e 20060102150405 http://e/ text/html 200 E 1\n",
+		"k3": "f 20060102150405 http://f/ text/html 200 F 1\n\nk3\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("limit") != "2" || r.URL.Query().Get("showResumeKey") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(batches[r.URL.Query().Get("resumeKey")]))
+	}))
+	defer srv.Close()
+	paginated, _ := NewCDXAPI("archive.org")
+	paginated.SetPagination(true, 1)
+	tests := []struct {
+		name      string
+		cdx       *CDXAPI
+		key       string
+		batchSize int
+		want      string
+		wantKey   string
+		wantErr   bool
+	}{
+		{"ErrorPaginationResumption", paginated, "", 2, "", "", true},
+		{"ErrorInvalidNumber", nil, "", 0, "", "", true},
+		{"All batches", nil, "", 2, "ABCDE", "k2", false},
+		{"Resumed", nil, "k1", 2, "CDE", "k2", false},
+		{"Repeated key", nil, "k3", 2, "F", "k3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx := tt.cdx
+			if cdx == nil {
+				cdx, _ = NewCDXAPI("archive.org")
+				cdx.SetCDXEndpoint(srv.URL)
+				cdx.SetResumptionKey(tt.key != "", tt.key)
+			}
+			it, err := cdx.IterateAll(tt.batchSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CDXAPI.IterateAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer it.Close()
+			got := ""
+			for it.Next() {
+				got += it.Result().Digest
+			}
+			if it.Err() != nil || got != tt.want {
+				t.Errorf("CDXIterator yielded %v, %v, want %v", got, it.Err(), tt.want)
+			}
+			if it.ResumptionKey() != tt.wantKey {
+				t.Errorf("CDXIterator.ResumptionKey() = %v, want %v", it.ResumptionKey(), tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_IteratePagesContext(t *testing.T) {
+	pages := map[string]string{
+		"0": "a 20060102150405 http://a/ text/html 200 A 1\nb 20060102150405 http://b/ text/html 200 B 1\n",
+		"1": "",
+		"2": "c 20060102150405 http://c/ text/html 200 C 1\n",
+	}
+	newServer := func(numPages string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("showNumPages") == "true" {
+				w.Write([]byte(numPages))
+				return
+			}
+			w.Write([]byte(pages[r.URL.Query().Get("page")]))
+		}))
+	}
+	tests := []struct {
+		name     string
+		numPages string
+		want     string
+		wantErr  bool
+	}{
+		{"All pages", "3", "ABC", false},
+		{"No pages", "0", "", false},
+		{"ErrorMalformedResult", "x", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(tt.numPages)
+			defer srv.Close()
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(srv.URL)
+			it, err := cdx.IteratePagesContext(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CDXAPI.IteratePagesContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer it.Close()
+			got := ""
+			for it.Next() {
+				got += it.Result().Digest
+			}
+			if it.Err() != nil || got != tt.want {
+				t.Errorf("CDXIterator yielded %v, %v, want %v", got, it.Err(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_IterateFields(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name   string
+		format outputFormat
+		flds   []field
+		body   string
+		want   CDXResult
+	}{
+		{"JSON header", OutputFormatJSON, []field{FieldDigest, FieldTimestamp}, `[["digest","unknown","timestamp"],["AAAA","x","20060102150405"]]`,
+			CDXResult{Digest: "AAAA", Timestamp: tm}},
+		{"JSON default header", OutputFormatJSON, nil, `[["original","statuscode"],["http://archive.org/","200"]]`,
+			CDXResult{Original: "http://archive.org/", StatusCode: 200}},
+		{"CDX field list", OutputFormatCDX, []field{FieldOriginal, FieldLength}, "http://archive.org/ 42\n",
+			CDXResult{Original: "http://archive.org/", Length: 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fl string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fl = r.URL.Query().Get("fl")
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(srv.URL)
+			cdx.SetOutputFormat(tt.format)
+			if tt.flds != nil {
+				cdx.SetFields(tt.flds...)
+			}
+			results, err := cdx.Perform()
+			if err != nil || len(results) != 1 {
+				t.Fatalf("CDXAPI.Perform() = %v, %v, want a single result", results, err)
+			}
+			got := results[0]
+			got.Data, got.cdx = nil, nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CDXAPI.Perform() = %v, want %v", got, tt.want)
+			}
+			if tt.flds != nil && fl == "" {
+				t.Errorf("CDXAPI.Perform() did not send fl")
+			}
+		})
+	}
+}
+
+func TestCDXAPI_IterateCounters(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	end, _ := time.Parse("20060102150405", "20070102150405")
+	tests := []struct {
+		name   string
+		format outputFormat
+		body   string
+		want   CDXResult
+	}{
+		{"JSON", OutputFormatJSON, `[["timestamp","digest","dupecount","skipcount","endtimestamp"],["20060102150405","AAAA","3","7","20070102150405"]]`,
+			CDXResult{Timestamp: tm, Digest: "AAAA", DupeCount: 3, SkipCount: 7, EndTimestamp: end}},
+		{"CDX", OutputFormatCDX, "20060102150405 AAAA 3 7 20070102150405\n",
+			CDXResult{Timestamp: tm, Digest: "AAAA", DupeCount: 3, SkipCount: 7, EndTimestamp: end}},
+		{"CDX without end timestamp", OutputFormatCDX, "20060102150405 AAAA 3 7 -\n",
+			CDXResult{Timestamp: tm, Digest: "AAAA", DupeCount: 3, SkipCount: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newCDXServer(tt.body)
+			defer srv.Close()
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(srv.URL)
+			cdx.SetOutputFormat(tt.format)
+			cdx.SetFields(FieldTimestamp, FieldDigest)
+			cdx.SetShowDupeCount(true)
+			cdx.SetShowSkipCount(true, true)
+			results, err := cdx.Perform()
+			if err != nil || len(results) != 1 {
+				t.Fatalf("CDXAPI.Perform() = %v, %v, want a single result", results, err)
+			}
+			got := results[0]
+			got.Data, got.cdx = nil, nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CDXAPI.Perform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
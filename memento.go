@@ -0,0 +1,304 @@
+package simplewayback
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// Memento is a capture of a resource as defined by RFC 7089, e.g. an entry of a TimeMap or the
+// result of a TimeGate negotiation. The embedded CDXResult only holds Original and Timestamp;
+// its Data fetches the memento from URL.
+type Memento struct {
+	CDXResult
+	// URL is the URI-M of the memento
+	URL string `json:"url"`
+	// Rel holds the relation types of the memento, e.g. "first memento"
+	Rel string `json:"rel,omitempty"`
+}
+
+// link is an entry of a link-format document (RFC 6690) or a Link header (RFC 8288)
+type link struct {
+	uri    string
+	params map[string]string
+}
+
+// hasRel reports whether l has the relation type rel
+func (l link) hasRel(rel string) bool {
+	for _, r := range strings.Fields(l.params["rel"]) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTimeMap is a JSON TimeMap as served by the Memento aggregator
+type jsonTimeMap struct {
+	OriginalURI string `json:"original_uri"`
+	Mementos    struct {
+		List []struct {
+			Datetime time.Time `json:"datetime"`
+			URI      string    `json:"uri"`
+		} `json:"list"`
+	} `json:"mementos"`
+}
+
+// SetTimeMapEndpoint sets the base URL of the Memento TimeMap. TimeMaps are requested as
+// <endpoint>/<url>, e.g. "http://timetravel.mementoweb.org/timemap/json" for the Memento
+// aggregator.
+func (cdx *CDXAPI) SetTimeMapEndpoint(endpoint string) error {
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	cdx.timeMapEndpoint = strings.TrimRight(endpoint, "/")
+	return nil
+}
+
+// TimeMapEndpoint getter
+func (cdx *CDXAPI) TimeMapEndpoint() string {
+	if cdx == nil || cdx.timeMapEndpoint == "" {
+		return timeMapURL
+	}
+	return cdx.timeMapEndpoint
+}
+
+// ResetTimeMapEndpoint resets the TimeMap endpoint (default: https://web.archive.org/web/timemap/link)
+func (cdx *CDXAPI) ResetTimeMapEndpoint() {
+	cdx.timeMapEndpoint = ""
+}
+
+// SetTimeGateEndpoint sets the base URL of the Memento TimeGate. TimeGates are requested as
+// <endpoint>/<url>.
+func (cdx *CDXAPI) SetTimeGateEndpoint(endpoint string) error {
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	cdx.timeGateEndpoint = strings.TrimRight(endpoint, "/")
+	return nil
+}
+
+// TimeGateEndpoint getter
+func (cdx *CDXAPI) TimeGateEndpoint() string {
+	if cdx == nil || cdx.timeGateEndpoint == "" {
+		return cdx.ReplayEndpoint()
+	}
+	return cdx.timeGateEndpoint
+}
+
+// ResetTimeGateEndpoint resets the TimeGate endpoint (default: the replay endpoint)
+func (cdx *CDXAPI) ResetTimeGateEndpoint() {
+	cdx.timeGateEndpoint = ""
+}
+
+// TimeMap requests the Memento TimeMap of the URL of cdx and returns its mementos in the order
+// of the TimeMap. Link-format (application/link-format) and JSON TimeMaps are supported. Only
+// the URL of cdx is used, all other search parameters are ignored.
+func (cdx *CDXAPI) TimeMap() ([]Memento, error) {
+	return cdx.TimeMapContext(context.Background())
+}
+
+// TimeMapContext is like TimeMap, but the request and the Data of the returned mementos are
+// bound to ctx
+func (cdx *CDXAPI) TimeMapContext(ctx context.Context) ([]Memento, error) {
+	if cdx.URL() == "" {
+		return nil, ErrorInvalidURL
+	}
+	qry, err := cdx.get(ctx, cdx.TimeMapEndpoint()+"/"+cdx.URL(), false)
+	if err != nil {
+		return nil, err
+	}
+	defer qry.Close()
+	body, err := ioutil.ReadAll(qry)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(qry.resp.Header.Get("Content-Type"))
+	if mediaType == "application/json" || (mediaType != "application/link-format" && strings.HasPrefix(strings.TrimSpace(string(body)), "{")) {
+		return cdx.parseJSONTimeMap(ctx, body)
+	}
+	return cdx.parseLinkTimeMap(ctx, qry.resp.Request.URL, string(body))
+}
+
+// TimeGate negotiates the memento of the URL of cdx closest to datetime with the Memento
+// TimeGate (Accept-Datetime). Only the URL of cdx is used, all other search parameters are
+// ignored.
+func (cdx *CDXAPI) TimeGate(datetime time.Time) (*Memento, error) {
+	return cdx.TimeGateContext(context.Background(), datetime)
+}
+
+// TimeGateContext is like TimeGate, but the request and the Data of the returned memento are
+// bound to ctx
+func (cdx *CDXAPI) TimeGateContext(ctx context.Context, datetime time.Time) (*Memento, error) {
+	if cdx.URL() == "" {
+		return nil, ErrorInvalidURL
+	}
+	req, err := http.NewRequest("GET", cdx.TimeGateEndpoint()+"/"+cdx.URL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept-Datetime", datetime.UTC().Format(http.TimeFormat))
+	// the TimeGate answers with a redirect to the selected memento, which is not fetched here
+	client := *cdx.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := cdx.doClient(&client, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return nil, newAPIError(resp)
+	}
+	resp.Body.Close()
+	memento := &Memento{CDXResult: CDXResult{Original: cdx.URL(), cdx: cdx}}
+	var location *neturl.URL
+	if resp.StatusCode >= 300 {
+		location, err = resp.Location()
+	} else {
+		// 200-style negotiation: the TimeGate is the memento itself
+		location, err = resp.Request.URL.Parse(resp.Header.Get("Content-Location"))
+	}
+	if err != nil {
+		return nil, ErrorMalformedResult
+	}
+	memento.URL = location.String()
+	memento.Timestamp, _ = http.ParseTime(resp.Header.Get("Memento-Datetime"))
+	for _, l := range parseLinks(strings.Join(resp.Header["Link"], ",")) {
+		uri, err := resp.Request.URL.Parse(l.uri)
+		if err != nil {
+			continue
+		}
+		switch {
+		case l.hasRel("original"):
+			memento.Original = uri.String()
+		case l.hasRel("memento") && uri.String() == memento.URL:
+			memento.Rel = l.params["rel"]
+			if memento.Timestamp.IsZero() {
+				memento.Timestamp, _ = http.ParseTime(l.params["datetime"])
+			}
+		}
+	}
+	if memento.Timestamp.IsZero() {
+		match := replayTimestamp.FindStringSubmatch(location.Path)
+		if match == nil {
+			return nil, ErrorMalformedResult
+		}
+		memento.Timestamp, _ = time.Parse("20060102150405", match[1])
+	}
+	memento.url = memento.URL
+	memento.Data = memento.DataContext(ctx)
+	return memento, nil
+}
+
+// parseLinkTimeMap parses a link-format TimeMap. Relative URIs are resolved against base.
+func (cdx *CDXAPI) parseLinkTimeMap(ctx context.Context, base *neturl.URL, body string) ([]Memento, error) {
+	links := parseLinks(body)
+	original := cdx.URL()
+	for _, l := range links {
+		if l.hasRel("original") {
+			original = l.uri
+		}
+	}
+	var mementos []Memento
+	for _, l := range links {
+		if !l.hasRel("memento") {
+			continue
+		}
+		uri, err := base.Parse(l.uri)
+		if err != nil {
+			return nil, ErrorMalformedResult
+		}
+		timestamp, err := http.ParseTime(l.params["datetime"])
+		if err != nil {
+			return nil, ErrorMalformedResult
+		}
+		mementos = append(mementos, cdx.newMemento(ctx, original, uri.String(), l.params["rel"], timestamp))
+	}
+	return mementos, nil
+}
+
+// parseJSONTimeMap parses a JSON TimeMap
+func (cdx *CDXAPI) parseJSONTimeMap(ctx context.Context, body []byte) ([]Memento, error) {
+	var timeMap jsonTimeMap
+	if err := json.Unmarshal(body, &timeMap); err != nil {
+		return nil, ErrorMalformedResult
+	}
+	original := timeMap.OriginalURI
+	if original == "" {
+		original = cdx.URL()
+	}
+	mementos := make([]Memento, 0, len(timeMap.Mementos.List))
+	for _, m := range timeMap.Mementos.List {
+		mementos = append(mementos, cdx.newMemento(ctx, original, m.URI, "memento", m.Datetime))
+	}
+	return mementos, nil
+}
+
+// newMemento creates a memento whose Data is bound to ctx
+func (cdx *CDXAPI) newMemento(ctx context.Context, original, uri, rel string, timestamp time.Time) Memento {
+	memento := Memento{CDXResult: CDXResult{Original: original, Timestamp: timestamp, cdx: cdx, url: uri}, URL: uri, Rel: rel}
+	memento.Data = memento.DataContext(ctx)
+	return memento
+}
+
+// parseLinks parses a link-format document or Link header, e.g.
+// <http://example.org/>; rel="original", <http://archive.org/web/2006/http://example.org/>; rel="memento"; datetime="Mon, 02 Jan 2006 15:04:05 GMT"
+// Malformed entries are skipped.
+func parseLinks(s string) []link {
+	var links []link
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			return links
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			return links
+		}
+		l := link{uri: strings.TrimSpace(s[start+1 : start+end]), params: map[string]string{}}
+		s = s[start+end+1:]
+		// parameters: ; name="value" or ; name=value, terminated by a comma outside of quotes
+		for {
+			s = strings.TrimLeft(s, " \t\r\n")
+			if !strings.HasPrefix(s, ";") {
+				break
+			}
+			s = strings.TrimLeft(s[1:], " \t\r\n")
+			i := strings.IndexAny(s, "=;,")
+			if i < 0 {
+				i = len(s)
+			}
+			name := strings.ToLower(strings.TrimSpace(s[:i]))
+			s = s[i:]
+			value := ""
+			if strings.HasPrefix(s, "=") {
+				s = strings.TrimLeft(s[1:], " \t\r\n")
+				if strings.HasPrefix(s, `"`) {
+					if i = strings.IndexByte(s[1:], '"'); i >= 0 {
+						value, s = s[1:i+1], s[i+2:]
+					} else {
+						value, s = s[1:], ""
+					}
+				} else {
+					i = strings.IndexAny(s, ";,")
+					if i < 0 {
+						i = len(s)
+					}
+					value, s = strings.TrimSpace(s[:i]), s[i:]
+				}
+			}
+			if _, ok := l.params[name]; !ok && name != "" {
+				l.params[name] = value
+			}
+		}
+		links = append(links, l)
+	}
+}
@@ -0,0 +1,211 @@
+package simplewayback
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []link
+	}{
+		{"Empty", "", nil},
+		{"Single", `<http://example.org/>;rel="original"`, []link{{"http://example.org/", map[string]string{"rel": "original"}}}},
+		{"Quoted comma", `<http://a/>; rel="memento"; datetime="Mon, 02 Jan 2006 15:04:05 GMT",` + "\n" + `<http://b/> ; rel=timegate`,
+			[]link{{"http://a/", map[string]string{"rel": "memento", "datetime": "Mon, 02 Jan 2006 15:04:05 GMT"}}, {"http://b/", map[string]string{"rel": "timegate"}}}},
+		{"Unterminated quote", `<http://a/>; rel="memento`, []link{{"http://a/", map[string]string{"rel": "memento"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLinks(tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLinks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_SetTimeMapEndpoint(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"ErrorInvalidEndpoint", "timetravel.mementoweb.org/timemap/json", timeMapURL, true},
+		{"Valid endpoint", "http://timetravel.mementoweb.org/timemap/json/", "http://timetravel.mementoweb.org/timemap/json", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetTimeMapEndpoint(tt.endpoint); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetTimeMapEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := cdx.TimeMapEndpoint(); got != tt.want {
+				t.Errorf("CDXAPI.TimeMapEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	cdx.ResetTimeMapEndpoint()
+	if got := cdx.TimeMapEndpoint(); got != timeMapURL {
+		t.Errorf("CDXAPI.ResetTimeMapEndpoint() = %v, want %v", got, timeMapURL)
+	}
+}
+
+func TestCDXAPI_TimeGateEndpoint(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	if got := cdx.TimeGateEndpoint(); got != dataURL {
+		t.Errorf("CDXAPI.TimeGateEndpoint() = %v, want %v", got, dataURL)
+	}
+	cdx.SetReplayEndpoint("http://localhost:8080/coll")
+	if got := cdx.TimeGateEndpoint(); got != "http://localhost:8080/coll" {
+		t.Errorf("CDXAPI.TimeGateEndpoint() = %v, want the replay endpoint", got)
+	}
+	cdx.SetTimeGateEndpoint("http://localhost:8080/timegate")
+	if got := cdx.TimeGateEndpoint(); got != "http://localhost:8080/timegate" {
+		t.Errorf("CDXAPI.TimeGateEndpoint() = %v, want http://localhost:8080/timegate", got)
+	}
+	cdx.ResetTimeGateEndpoint()
+	if got := cdx.TimeGateEndpoint(); got != "http://localhost:8080/coll" {
+		t.Errorf("CDXAPI.ResetTimeGateEndpoint() = %v, want the replay endpoint", got)
+	}
+}
+
+func TestCDXAPI_TimeMap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") != "" {
+			// the API key must not leak to other archives
+			http.Error(w, "unexpected cookie", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/timemap/link/archive.org":
+			w.Header().Set("Content-Type", "application/link-format")
+			w.Write([]byte(`<http://archive.org/>; rel="original",
+<http://localhost/timemap/link/archive.org>; rel="self"; type="application/link-format",
+</web/20060102150405/http://archive.org/>; rel="first memento"; datetime="Mon, 02 Jan 2006 15:04:05 GMT",
+<http://other.org/20070102150405/http://archive.org/>; rel="memento"; datetime="Tue, 02 Jan 2007 15:04:05 GMT"
+`))
+		case "/timemap/json/archive.org":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"original_uri":"http://archive.org/","mementos":{"list":[` +
+				`{"datetime":"2006-01-02T15:04:05Z","uri":"http://other.org/20060102150405/http://archive.org/"}]}}`))
+		case "/timemap/malformed/archive.org":
+			w.Write([]byte(`<http://archive.org/>; rel="memento"; datetime="yesterday"`))
+		case "/web/20060102150405id_/http://archive.org/":
+			w.Write([]byte("raw"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name      string
+		endpoint  string
+		wantURLs  []string
+		wantTimes []string
+		wantErr   error
+	}{
+		{"Link format", "/timemap/link", []string{srv.URL + "/web/20060102150405/http://archive.org/", "http://other.org/20070102150405/http://archive.org/"},
+			[]string{"20060102150405", "20070102150405"}, nil},
+		{"JSON", "/timemap/json", []string{"http://other.org/20060102150405/http://archive.org/"}, []string{"20060102150405"}, nil},
+		{"ErrorMalformedResult", "/timemap/malformed", nil, nil, ErrorMalformedResult},
+		{"ErrorNotFound", "/timemap/missing", nil, nil, ErrorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetAPIKey("SECRET")
+			cdx.SetTimeMapEndpoint(srv.URL + tt.endpoint)
+			cdx.SetReplayEndpoint(srv.URL + "/web")
+			cdx.SetReplayMode(ReplayModeIdentity)
+			got, err := cdx.TimeMap()
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("CDXAPI.TimeMap() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.wantURLs) {
+				t.Fatalf("CDXAPI.TimeMap() = %v, want %d mementos", got, len(tt.wantURLs))
+			}
+			for i, memento := range got {
+				if memento.URL != tt.wantURLs[i] || memento.Timestamp.Format("20060102150405") != tt.wantTimes[i] || memento.Original != "http://archive.org/" {
+					t.Errorf("CDXAPI.TimeMap()[%d] = %+v", i, memento)
+				}
+			}
+			if tt.name == "Link format" {
+				// mementos within the replay endpoint honor the replay mode
+				if body, err := ioutil.ReadAll(got[0].Data); err != nil || string(body) != "raw" {
+					t.Errorf("Memento.Data = %q, %v, want raw", body, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCDXAPI_TimeGateContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		datetime, _ := http.ParseTime(r.Header.Get("Accept-Datetime"))
+		switch {
+		case r.URL.Path == "/web/archive.org" && datetime.Year() == 2006:
+			w.Header().Set("Location", "/web/20060102150405/http://archive.org/")
+			w.Header().Add("Link", `<http://archive.org/>; rel="original", </web/timemap/link/http://archive.org/>; rel="timemap"`)
+			w.Header().Add("Link", `</web/20060102150405/http://archive.org/>; rel="first memento"; datetime="Mon, 02 Jan 2006 15:04:05 GMT"`)
+			w.WriteHeader(http.StatusFound)
+		case r.URL.Path == "/web/archive.org" && datetime.Year() == 2007:
+			w.Header().Set("Content-Location", "/web/20070102150405/http://archive.org/")
+			w.Header().Set("Memento-Datetime", "Tue, 02 Jan 2007 15:04:05 GMT")
+			w.Write([]byte("memento"))
+		case r.URL.Path == "/web/archive.org" && datetime.Year() == 2008:
+			w.Header().Set("Location", "/elsewhere")
+			w.WriteHeader(http.StatusFound)
+		case r.URL.Path == "/web/20060102150405/http://archive.org/":
+			w.Write([]byte("snapshot"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	tests := []struct {
+		name          string
+		year          int
+		wantURL       string
+		wantTimestamp string
+		wantRel       string
+		wantErr       error
+	}{
+		{"Redirect", 2006, srv.URL + "/web/20060102150405/http://archive.org/", "20060102150405", "first memento", nil},
+		{"200-style", 2007, srv.URL + "/web/20070102150405/http://archive.org/", "20070102150405", "", nil},
+		{"ErrorMalformedResult", 2008, "", "", "", ErrorMalformedResult},
+		{"ErrorNotFound", 2009, "", "", "", ErrorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cdx.TimeGateContext(context.Background(), time.Date(tt.year, 1, 1, 0, 0, 0, 0, time.UTC))
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("CDXAPI.TimeGateContext() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.URL != tt.wantURL || got.Timestamp.Format("20060102150405") != tt.wantTimestamp || got.Rel != tt.wantRel {
+				t.Errorf("CDXAPI.TimeGateContext() = %+v", got)
+			}
+			if tt.year == 2006 {
+				if got.Original != "http://archive.org/" {
+					t.Errorf("Memento.Original = %v, want http://archive.org/", got.Original)
+				}
+				if body, err := ioutil.ReadAll(got.Data); err != nil || string(body) != "snapshot" {
+					t.Errorf("Memento.Data = %q, %v, want snapshot", body, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package simplewayback
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// CDXPage holds the results of a single page fetched by FetchPages
+type CDXPage struct {
+	Page    int
+	Results []CDXResult
+	Err     error
+}
+
+// FetchPages fetches all pages of the result set using at most workers concurrent requests.
+// The number of pages is determined using NumPages first. Each page is delivered as a
+// CDXPage on the returned channel, which is closed once all pages have been delivered.
+// If ordered is true, pages are delivered in page order, otherwise as soon as they have
+// been fetched. A failing page is reported through CDXPage.Err and does not abort the walk.
+// The query parameters of cdx must not be changed until the channel is closed.
+//
+// Call stop if the channel is not read until it is closed, e.g. after the first failing page.
+// It stops the walk and releases all goroutines; calling it after the channel has been closed
+// is safe. stop only cancels the page queries, the Data readers of delivered results remain
+// usable.
+func (cdx *CDXAPI) FetchPages(workers int, ordered bool) (pages <-chan CDXPage, stop func(), err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if pages, err = cdx.fetchPages(ctx, context.Background(), workers, ordered); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return pages, cancel, nil
+}
+
+// FetchPagesContext is like FetchPages, but all queries are bound to ctx. Cancelling ctx
+// stops the walk and closes the channel without delivering the remaining pages. Callers that
+// stop reading the channel before it is closed must cancel ctx, otherwise the walk blocks
+// forever. The Data readers of the delivered results inherit ctx as well.
+func (cdx *CDXAPI) FetchPagesContext(ctx context.Context, workers int, ordered bool) (<-chan CDXPage, error) {
+	return cdx.fetchPages(ctx, ctx, workers, ordered)
+}
+
+// fetchPages implements FetchPagesContext. The page queries are bound to ctx, the Data readers
+// of the results to dataCtx.
+func (cdx *CDXAPI) fetchPages(ctx, dataCtx context.Context, workers int, ordered bool) (<-chan CDXPage, error) {
+	if workers <= 0 {
+		return nil, ErrorInvalidNumber
+	}
+	numPages, err := cdx.NumPagesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := cdx.pageURLs(numPages)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make(chan int)
+	fetched := make(chan CDXPage)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < numPages; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range jobs {
+				results, err := cdx.fetchPage(ctx, dataCtx, urls[page])
+				select {
+				case fetched <- CDXPage{Page: page, Results: results, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for page := 0; page < numPages; page++ {
+			select {
+			case jobs <- page:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(fetched)
+	}()
+	if !ordered {
+		return fetched, nil
+	}
+	// hold back pages that have been fetched early until their predecessors arrived
+	pages := make(chan CDXPage)
+	go func() {
+		defer close(pages)
+		pending := map[int]CDXPage{}
+		next := 0
+		for page := range fetched {
+			pending[page.Page] = page
+			for p, ok := pending[next]; ok; p, ok = pending[next] {
+				select {
+				case pages <- p:
+				case <-ctx.Done():
+					return
+				}
+				delete(pending, next)
+				next++
+			}
+		}
+	}()
+	return pages, nil
+}
+
+// pageURLs builds the query URLs of the pages 0..numPages-1. The pagination settings of
+// cdx are restored afterwards.
+func (cdx *CDXAPI) pageURLs(numPages int) ([]string, error) {
+	enabled, current := cdx.usePagination, cdx.page
+	defer func() {
+		if enabled {
+			cdx.SetPagination(true, current)
+		} else {
+			cdx.ResetPagination()
+		}
+	}()
+	urls := make([]string, numPages)
+	var buf bytes.Buffer
+	for page := range urls {
+		if err := cdx.SetPagination(true, page); err != nil {
+			return nil, err
+		}
+		if err := cdx.buildURL(&buf); err != nil {
+			return nil, err
+		}
+		urls[page] = buf.String()
+	}
+	return urls, nil
+}
+
+// fetchPage queries a single page bound to ctx and collects all of its results, whose Data
+// readers are bound to dataCtx
+func (cdx *CDXAPI) fetchPage(ctx, dataCtx context.Context, url string) ([]CDXResult, error) {
+	qry, err := cdx.query(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	it := &CDXIterator{cdx: cdx, ctx: dataCtx}
+	it.open(qry)
+	defer it.Close()
+	results := []CDXResult{}
+	for it.Next() {
+		results = append(results, it.Result())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
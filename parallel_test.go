@@ -0,0 +1,195 @@
+package simplewayback
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCDXAPI_FetchPagesContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("showNumPages") == "true" {
+			w.Write([]byte("4"))
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "0":
+			// deliver the first page last to check the ordering
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("a 20060102150405 http://a/ text/html 200 A 1\n"))
+		case "1":
+			w.Write([]byte("b 20060102150405 http://b/ text/html 200 B 1\nc 20060102150405 http://c/ text/html 200 C 1\n"))
+		case "2":
+			w.Write([]byte("broken\n"))
+		case "3":
+			w.Write([]byte("d 20060102150405 http://d/ text/html 200 D 1\n"))
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name      string
+		workers   int
+		ordered   bool
+		wantPages []int
+		wantErr   bool
+	}{
+		{"ErrorInvalidNumber", 0, true, nil, true},
+		{"Ordered", 3, true, []int{0, 1, 2, 3}, false},
+		{"Unordered", 3, false, []int{0, 1, 2, 3}, false},
+		{"More workers than pages", 10, true, []int{0, 1, 2, 3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(srv.URL)
+			pages, err := cdx.FetchPagesContext(context.Background(), tt.workers, tt.ordered)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CDXAPI.FetchPagesContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []int
+			digests := map[int]string{}
+			for page := range pages {
+				got = append(got, page.Page)
+				if (page.Err != nil) != (page.Page == 2) {
+					t.Errorf("CDXPage %d error = %v", page.Page, page.Err)
+				}
+				for _, result := range page.Results {
+					digests[page.Page] += result.Digest
+				}
+			}
+			if !tt.ordered {
+				sort.Ints(got)
+			}
+			if len(got) != len(tt.wantPages) {
+				t.Fatalf("CDXAPI.FetchPagesContext() delivered pages %v, want %v", got, tt.wantPages)
+			}
+			for i := range got {
+				if got[i] != tt.wantPages[i] {
+					t.Errorf("CDXAPI.FetchPagesContext() delivered pages %v, want %v", got, tt.wantPages)
+				}
+			}
+			if digests[0] != "A" || digests[1] != "BC" || digests[3] != "D" {
+				t.Errorf("CDXAPI.FetchPagesContext() delivered results %v", digests)
+			}
+			if cdx.PaginationEnabled() {
+				t.Errorf("CDXAPI.FetchPagesContext() did not restore the pagination settings")
+			}
+		})
+	}
+}
+
+func TestCDXAPI_FetchPagesContextCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("showNumPages") == "true" {
+			w.Write([]byte("100"))
+			return
+		}
+		w.Write([]byte("a 20060102150405 http://a/ text/html 200 A 1\n"))
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	pages, err := cdx.FetchPagesContext(ctx, 2, true)
+	if err != nil {
+		t.Fatalf("CDXAPI.FetchPagesContext() error = %v", err)
+	}
+	<-pages
+	cancel()
+	n := 0
+	for range pages {
+		n++
+	}
+	if n >= 99 {
+		t.Errorf("CDXAPI.FetchPagesContext() delivered %d pages after cancel", n)
+	}
+}
+
+func TestCDXAPI_FetchPages(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("showNumPages") == "true" {
+			w.Write([]byte("100"))
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("broken\n"))
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL)
+	if _, _, err := cdx.FetchPages(0, true); err != ErrorInvalidNumber {
+		t.Errorf("CDXAPI.FetchPages() error = %v, want %v", err, ErrorInvalidNumber)
+	}
+	pages, stop, err := cdx.FetchPages(2, false)
+	if err != nil {
+		t.Fatalf("CDXAPI.FetchPages() error = %v", err)
+	}
+	// abandon the walk after the first failing page
+	if page := <-pages; page.Err == nil {
+		t.Errorf("CDXPage %d error = nil", page.Page)
+	}
+	stop()
+	// the channel is closed once the workers have been released
+	select {
+	case <-drain(pages):
+	case <-time.After(time.Second):
+		t.Fatalf("CDXAPI.FetchPages() did not stop")
+	}
+	stop()
+	if n := atomic.LoadInt32(&requests); n >= 100 {
+		t.Errorf("CDXAPI.FetchPages() sent %d requests after stop", n)
+	}
+}
+
+func TestCDXAPI_FetchPagesStop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("showNumPages") == "true":
+			w.Write([]byte("2"))
+		case r.URL.Path == "/cdx":
+			w.Write([]byte("a 20060102150405 http://a/ text/html 200 A 1\n"))
+		default:
+			w.Write([]byte("snapshot"))
+		}
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL + "/cdx")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	pages, stop, err := cdx.FetchPages(2, true)
+	if err != nil {
+		t.Fatalf("CDXAPI.FetchPages() error = %v", err)
+	}
+	var results []CDXResult
+	for page := range pages {
+		results = append(results, page.Results...)
+	}
+	stop()
+	if len(results) != 2 {
+		t.Fatalf("CDXAPI.FetchPages() delivered %d results, want 2", len(results))
+	}
+	// stopping the walk must not cancel the snapshots of delivered results
+	if body, err := ioutil.ReadAll(results[0].Data); err != nil || string(body) != "snapshot" {
+		t.Errorf("CDXResult.Data after stop = %q, %v, want snapshot", body, err)
+	}
+}
+
+// drain reads pages until it is closed and closes the returned channel afterwards
+func drain(pages <-chan CDXPage) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range pages {
+		}
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,87 @@
+package simplewayback
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of requests sent to the archive using a token bucket. A single
+// RateLimiter can be shared among multiple CDXAPI values, so all CDX searches and snapshot
+// downloads of the process stay under a global budget. It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing rps requests per second on average and
+// bursts of up to burst requests
+func NewRateLimiter(rps float64, burst int) (*RateLimiter, error) {
+	if rps <= 0 || burst <= 0 {
+		return nil, ErrorInvalidRateLimit
+	}
+	return &RateLimiter{rate: rps, burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+// Rate returns the number of requests per second allowed on average
+func (l *RateLimiter) Rate() float64 {
+	return l.rate
+}
+
+// Burst returns the maximum number of requests allowed at once
+func (l *RateLimiter) Burst() int {
+	return int(l.burst)
+}
+
+// Wait blocks until the next request may be sent or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// reserve a token, possibly going into debt that later callers have to wait for
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// hand back the unused reservation
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// SetRateLimiter sets a rate limiter for CDX searches and snapshot downloads. The limiter
+// may be shared with other CDXAPI values.
+func (cdx *CDXAPI) SetRateLimiter(limiter *RateLimiter) error {
+	if limiter == nil {
+		return ErrorInvalidRateLimit
+	}
+	cdx.limiter = limiter
+	return nil
+}
+
+// RateLimiter getter
+func (cdx *CDXAPI) RateLimiter() *RateLimiter {
+	return cdx.limiter
+}
+
+// ResetRateLimiter resets the rate limiter (default: no rate limit)
+func (cdx *CDXAPI) ResetRateLimiter() {
+	cdx.limiter = nil
+}
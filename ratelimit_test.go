@@ -0,0 +1,117 @@
+package simplewayback
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rps     float64
+		burst   int
+		wantErr bool
+	}{
+		{"ErrorInvalidRateLimit rps", 0, 1, true},
+		{"ErrorInvalidRateLimit burst", 1, 0, true},
+		{"Valid limiter", 2.5, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRateLimiter(tt.rps, tt.burst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRateLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (got.Rate() != tt.rps || got.Burst() != tt.burst) {
+				t.Errorf("NewRateLimiter() = %v/%v, want %v/%v", got.Rate(), got.Burst(), tt.rps, tt.burst)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter, _ := NewRateLimiter(50, 2)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Errorf("RateLimiter.Wait() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// 2 requests are covered by the burst, the remaining 4 take 20ms each
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("RateLimiter.Wait() let 6 requests pass within %v", elapsed)
+	}
+}
+
+func TestRateLimiter_WaitCancel(t *testing.T) {
+	limiter, _ := NewRateLimiter(0.001, 1)
+	limiter.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("RateLimiter.Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCDXAPI_SetRateLimiter(t *testing.T) {
+	limiter, _ := NewRateLimiter(1, 1)
+	cdx, _ := NewCDXAPI("archive.org")
+	tests := []struct {
+		name    string
+		limiter *RateLimiter
+		wantErr bool
+	}{
+		{"ErrorInvalidRateLimit", nil, true},
+		{"Valid limiter", limiter, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetRateLimiter(tt.limiter); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetRateLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && cdx.RateLimiter() != tt.limiter {
+				t.Errorf("CDXAPI.RateLimiter() = %v, want %v", cdx.RateLimiter(), tt.limiter)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetRateLimiter(t *testing.T) {
+	limiter, _ := NewRateLimiter(1, 1)
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetRateLimiter(limiter)
+	cdx.ResetRateLimiter()
+	if cdx.RateLimiter() != nil {
+		t.Errorf("CDXAPI.ResetRateLimiter() didn't reset the rate limiter")
+	}
+}
+
+func TestCDXAPI_doRateLimited(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	limiter, _ := NewRateLimiter(0.001, 1)
+	cdx1, _ := NewCDXAPI("archive.org")
+	cdx1.SetCDXEndpoint(srv.URL + "/cdx/search/cdx")
+	cdx1.SetRateLimiter(limiter)
+	cdx2, _ := NewCDXAPI("archive.org")
+	cdx2.SetReplayEndpoint(srv.URL + "/web")
+	cdx2.SetRateLimiter(limiter)
+	// the first request consumes the whole budget shared by both CDXAPIs
+	if _, err := cdx1.Perform(); err != nil {
+		t.Fatalf("CDXAPI.Perform() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if _, err := cdx2.do(req.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("CDXAPI.do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,157 @@
+package simplewayback
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how failed CDX searches and snapshot downloads are retried. Only
+// idempotent requests failing with a transient error are retried: connection resets,
+// timeouts and the status codes 408, 429, 502, 503 and 504.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per request, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with every further retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts, including delays requested by the
+	// server via Retry-After. Zero means no cap.
+	MaxDelay time.Duration
+	// Jitter randomizes each backoff delay by up to +/- Jitter*delay (0 <= Jitter <= 1)
+	Jitter float64
+}
+
+// DefaultRetryPolicy is a reasonable retry policy for the Wayback Machine
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.2}
+
+// retryStatusCodes lists the status codes of transient failures
+var retryStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:     true,
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// SetRetryPolicy sets the retry policy used for CDX searches and snapshot downloads
+func (cdx *CDXAPI) SetRetryPolicy(policy RetryPolicy) error {
+	if policy.MaxAttempts <= 0 || policy.BaseDelay < 0 || policy.MaxDelay < 0 || policy.Jitter < 0 || policy.Jitter > 1 {
+		return ErrorInvalidRetryPolicy
+	}
+	cdx.retry = policy
+	return nil
+}
+
+// RetryPolicy getter
+func (cdx *CDXAPI) RetryPolicy() RetryPolicy {
+	if cdx.retry.MaxAttempts == 0 {
+		return RetryPolicy{MaxAttempts: 1}
+	}
+	return cdx.retry
+}
+
+// ResetRetryPolicy resets the retry policy (default: no retries)
+func (cdx *CDXAPI) ResetRetryPolicy() {
+	cdx.retry = RetryPolicy{}
+}
+
+// do sends req using the HTTP client, rate limiter and retry policy of cdx. It is safe to call on a
+// nil *CDXAPI. If the last attempt fails with a transient status code, its response is
+// returned as is.
+func (cdx *CDXAPI) do(req *http.Request) (*http.Response, error) {
+	return cdx.doClient(cdx.httpClient(), req)
+}
+
+// doClient is like do, but sends req using client
+func (cdx *CDXAPI) doClient(client *http.Client, req *http.Request) (*http.Response, error) {
+	policy := RetryPolicy{MaxAttempts: 1}
+	if cdx != nil {
+		policy = cdx.RetryPolicy()
+	}
+	// requests with a body are not idempotent
+	if (req.Method != "GET" && req.Method != "HEAD") || req.Body != nil {
+		policy.MaxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		if cdx != nil && cdx.limiter != nil {
+			if err := cdx.limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := client.Do(req)
+		if attempt >= policy.MaxAttempts || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		delay := policy.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+				if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+					delay = policy.MaxDelay
+				}
+			}
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay == 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if policy.Jitter > 0 {
+		delay += time.Duration(policy.Jitter * (2*rand.Float64() - 1) * float64(delay))
+	}
+	return delay
+}
+
+// retryable reports whether a request failed with a transient error
+func retryable(resp *http.Response, err error) bool {
+	if err == nil {
+		return retryStatusCodes[resp.StatusCode]
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryAfter parses the Retry-After header of resp (delay-seconds or HTTP-date)
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,199 @@
+package simplewayback
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCDXAPI_SetRetryPolicy(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		wantErr bool
+	}{
+		{"ErrorInvalidRetryPolicy attempts", RetryPolicy{MaxAttempts: 0}, true},
+		{"ErrorInvalidRetryPolicy delay", RetryPolicy{MaxAttempts: 2, BaseDelay: -1}, true},
+		{"ErrorInvalidRetryPolicy jitter", RetryPolicy{MaxAttempts: 2, Jitter: 1.5}, true},
+		{"Valid policy", DefaultRetryPolicy, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetRetryPolicy(tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetRetryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && cdx.RetryPolicy() != tt.policy {
+				t.Errorf("CDXAPI.RetryPolicy() = %v, want %v", cdx.RetryPolicy(), tt.policy)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetRetryPolicy(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetRetryPolicy(DefaultRetryPolicy)
+	cdx.ResetRetryPolicy()
+	if cdx.RetryPolicy() != (RetryPolicy{MaxAttempts: 1}) {
+		t.Errorf("CDXAPI.ResetRetryPolicy() didn't reset the retry policy")
+	}
+}
+
+func TestCDXAPI_do(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		statuses     []int
+		retryAfter   string
+		policy       RetryPolicy
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{"Success", "GET", []int{200}, "", RetryPolicy{MaxAttempts: 3}, 200, 1},
+		{"Retry 503 and 429", "GET", []int{503, 429, 200}, "", RetryPolicy{MaxAttempts: 3}, 200, 3},
+		{"Retry-After", "GET", []int{429, 200}, "0", RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}, 200, 2},
+		{"Retry-After capped", "GET", []int{429, 200}, "3600", RetryPolicy{MaxAttempts: 3, MaxDelay: time.Millisecond}, 200, 2},
+		{"Exhausted", "GET", []int{503, 503, 503}, "", RetryPolicy{MaxAttempts: 2}, 503, 2},
+		{"Not transient", "GET", []int{404, 200}, "", RetryPolicy{MaxAttempts: 3}, 404, 1},
+		{"Not idempotent", "POST", []int{503, 200}, "", RetryPolicy{MaxAttempts: 3}, 503, 1},
+		{"No policy", "GET", []int{503, 200}, "", RetryPolicy{}, 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer srv.Close()
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.retry = tt.policy
+			req, _ := http.NewRequest(tt.method, srv.URL, nil)
+			resp, err := cdx.do(req)
+			if err != nil {
+				t.Fatalf("CDXAPI.do() error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus || attempts != tt.wantAttempts {
+				t.Errorf("CDXAPI.do() = %d after %d attempts, want %d after %d", resp.StatusCode, attempts, tt.wantStatus, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_doCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	if _, err := cdx.do(req.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("CDXAPI.do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCDXAPI_doSnapshot(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("snapshot"))
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL)
+	cdx.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	data, err := ioutil.ReadAll(CDXResult{Original: "http://archive.org/", cdx: cdx}.DataContext(context.Background()))
+	if err != nil || string(data) != "snapshot" {
+		t.Errorf("CDXResult.DataContext() = %q, %v, want snapshot", data, err)
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"First retry", RetryPolicy{BaseDelay: time.Second}, 1, time.Second, time.Second},
+		{"Exponential", RetryPolicy{BaseDelay: time.Second}, 4, 8 * time.Second, 8 * time.Second},
+		{"Capped", RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 10, 5 * time.Second, 5 * time.Second},
+		{"Jitter", RetryPolicy{BaseDelay: time.Second, Jitter: 0.5}, 1, 500 * time.Millisecond, 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Errorf("RetryPolicy.backoff() = %v, want [%v, %v]", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"Missing", "", 0, false},
+		{"Seconds", "120", 2 * time.Minute, true},
+		{"Past date", "Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+		{"Invalid", "soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			got, ok := retryAfter(resp)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_retryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// close the connection without an answer
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+	_, resetErr := http.Get(srv.URL)
+	tests := []struct {
+		name string
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{"429", &http.Response{StatusCode: 429}, nil, true},
+		{"200", &http.Response{StatusCode: 200}, nil, false},
+		{"Connection closed", nil, resetErr, true},
+		{"Other error", nil, strings.NewReader("").UnreadByte(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.resp, tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
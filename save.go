@@ -0,0 +1,159 @@
+package simplewayback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// defaultSavePoll is the default interval between two status requests of a capture job
+const defaultSavePoll = 5 * time.Second
+
+// saveStatus is the JSON response of Save Page Now to capture and status requests
+type saveStatus struct {
+	URL         string `json:"url"`
+	JobID       string `json:"job_id"`
+	Status      string `json:"status"`
+	StatusExt   string `json:"status_ext"`
+	Message     string `json:"message"`
+	OriginalURL string `json:"original_url"`
+	Timestamp   string `json:"timestamp"`
+	HTTPStatus  int    `json:"http_status"`
+}
+
+// SetSaveEndpoint sets the URL of Save Page Now. Capture jobs are submitted to <endpoint> and
+// polled at <endpoint>/status/<job id>.
+func (cdx *CDXAPI) SetSaveEndpoint(endpoint string) error {
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	cdx.saveEndpoint = strings.TrimRight(endpoint, "/")
+	return nil
+}
+
+// SaveEndpoint getter
+func (cdx *CDXAPI) SaveEndpoint() string {
+	if cdx.saveEndpoint == "" {
+		return saveURL
+	}
+	return cdx.saveEndpoint
+}
+
+// ResetSaveEndpoint resets the Save Page Now endpoint (default: https://web.archive.org/save)
+func (cdx *CDXAPI) ResetSaveEndpoint() {
+	cdx.saveEndpoint = ""
+}
+
+// SetSavePollInterval sets the interval between two status requests of a capture job
+func (cdx *CDXAPI) SetSavePollInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return ErrorInvalidNumber
+	}
+	cdx.savePoll = interval
+	return nil
+}
+
+// SavePollInterval getter
+func (cdx *CDXAPI) SavePollInterval() time.Duration {
+	if cdx.savePoll == 0 {
+		return defaultSavePoll
+	}
+	return cdx.savePoll
+}
+
+// ResetSavePollInterval resets the poll interval of capture jobs (default: 5s)
+func (cdx *CDXAPI) ResetSavePollInterval() {
+	cdx.savePoll = 0
+}
+
+// Save requests a new capture of the URL of cdx using Save Page Now and waits until the capture
+// job has finished. Requests are authenticated using the API key and secret, if set. Failed
+// captures are returned as *SaveError. Only the URL of cdx is used, all other search
+// parameters are ignored.
+func (cdx *CDXAPI) Save() (CDXResult, error) {
+	return cdx.SaveContext(context.Background())
+}
+
+// SaveContext is like Save, but the requests and the Data of the returned capture are bound to
+// ctx. Cancelling ctx stops waiting for the capture job, but does not cancel the job itself.
+func (cdx *CDXAPI) SaveContext(ctx context.Context) (CDXResult, error) {
+	if cdx.URL() == "" {
+		return CDXResult{}, ErrorInvalidURL
+	}
+	form := neturl.Values{}
+	form.Set("url", cdx.URL())
+	req, err := http.NewRequest("POST", cdx.SaveEndpoint(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return CDXResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	status, err := cdx.saveRequest(ctx, req)
+	if err != nil {
+		return CDXResult{}, err
+	}
+	if status.JobID == "" {
+		return CDXResult{}, &SaveError{StatusExt: status.StatusExt, Message: status.Message}
+	}
+	jobID := status.JobID
+	for {
+		switch status.Status {
+		case "success":
+			return cdx.savedResult(ctx, status)
+		case "error":
+			return CDXResult{}, &SaveError{JobID: jobID, StatusExt: status.StatusExt, Message: status.Message}
+		}
+		timer := time.NewTimer(cdx.SavePollInterval())
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return CDXResult{}, ctx.Err()
+		}
+		if req, err = http.NewRequest("GET", cdx.SaveEndpoint()+"/status/"+neturl.PathEscape(jobID), nil); err != nil {
+			return CDXResult{}, err
+		}
+		if status, err = cdx.saveRequest(ctx, req); err != nil {
+			return CDXResult{}, err
+		}
+	}
+}
+
+// saveRequest sends a Save Page Now request and decodes its response
+func (cdx *CDXAPI) saveRequest(ctx context.Context, req *http.Request) (*saveStatus, error) {
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if cdx.apiKey != "" && cdx.apiSecret != "" {
+		req.Header.Set("Authorization", "LOW "+cdx.apiKey+":"+cdx.apiSecret)
+	}
+	resp, err := cdx.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+	defer resp.Body.Close()
+	var status saveStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, ErrorMalformedResult
+	}
+	return &status, nil
+}
+
+// savedResult converts the status of a successful capture job into a CDXResult
+func (cdx *CDXAPI) savedResult(ctx context.Context, status *saveStatus) (CDXResult, error) {
+	result := CDXResult{Original: status.OriginalURL, StatusCode: status.HTTPStatus, cdx: cdx}
+	if result.Original == "" {
+		result.Original = cdx.URL()
+	}
+	var err error
+	if result.Timestamp, err = time.Parse("20060102150405", status.Timestamp); err != nil {
+		return CDXResult{}, ErrorMalformedResult
+	}
+	result.Data = result.DataContext(ctx)
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package simplewayback
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCDXAPI_SetSavePollInterval(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	tests := []struct {
+		name     string
+		interval time.Duration
+		want     time.Duration
+		wantErr  bool
+	}{
+		{"ErrorInvalidNumber", 0, defaultSavePoll, true},
+		{"Valid interval", time.Second, time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetSavePollInterval(tt.interval); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetSavePollInterval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := cdx.SavePollInterval(); got != tt.want {
+				t.Errorf("CDXAPI.SavePollInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	cdx.ResetSavePollInterval()
+	if got := cdx.SavePollInterval(); got != defaultSavePoll {
+		t.Errorf("CDXAPI.ResetSavePollInterval() = %v, want %v", got, defaultSavePoll)
+	}
+}
+
+func TestCDXAPI_SaveContext(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/save") && r.Header.Get("Authorization") != "LOW key:secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"You need to be logged in to use Save Page Now."}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/save":
+			switch r.FormValue("url") {
+			case "archive.org":
+				w.Write([]byte(`{"url":"archive.org","job_id":"spn2-ok"}`))
+			case "failing.org":
+				w.Write([]byte(`{"url":"failing.org","job_id":"spn2-failing"}`))
+			case "pending.org":
+				w.Write([]byte(`{"url":"pending.org","job_id":"spn2-pending"}`))
+			default:
+				w.Write([]byte(`{"status":"error","status_ext":"error:invalid-url-syntax","message":"Invalid URL syntax."}`))
+			}
+		case "/save/status/spn2-ok":
+			if atomic.AddInt32(&polls, 1) < 2 {
+				w.Write([]byte(`{"status":"pending","job_id":"spn2-ok"}`))
+				return
+			}
+			w.Write([]byte(`{"status":"success","job_id":"spn2-ok","original_url":"http://archive.org/","timestamp":"20060102150405","http_status":200}`))
+		case "/save/status/spn2-failing":
+			w.Write([]byte(`{"status":"error","job_id":"spn2-failing","status_ext":"error:too-many-redirects","message":"Too many redirects."}`))
+		case "/save/status/spn2-pending":
+			w.Write([]byte(`{"status":"pending","job_id":"spn2-pending"}`))
+		case "/web/20060102150405/http://archive.org/":
+			w.Write([]byte("snapshot"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	tests := []struct {
+		name      string
+		url       string
+		secret    string
+		timeout   time.Duration
+		wantErr   error
+		wantJobID string
+	}{
+		{"Success", "archive.org", "secret", time.Second, nil, ""},
+		{"ErrorSaveFailed rejected", "invalid", "secret", time.Second, ErrorSaveFailed, ""},
+		{"ErrorSaveFailed job", "failing.org", "secret", time.Second, ErrorSaveFailed, "spn2-failing"},
+		{"ErrorBadResponse unauthorized", "archive.org", "", time.Second, ErrorBadResponse, ""},
+		{"DeadlineExceeded", "pending.org", "secret", 50 * time.Millisecond, context.DeadlineExceeded, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx, _ := NewCDXAPI(tt.url)
+			cdx.SetAPIKey("key")
+			cdx.SetAPISecret(tt.secret)
+			cdx.SetSaveEndpoint(srv.URL + "/save")
+			cdx.SetReplayEndpoint(srv.URL + "/web")
+			cdx.SetSavePollInterval(10 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			got, err := cdx.SaveContext(ctx)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("CDXAPI.SaveContext() error = %v, want %v", err, tt.wantErr)
+			}
+			var saveErr *SaveError
+			if errors.As(err, &saveErr) && saveErr.JobID != tt.wantJobID {
+				t.Errorf("SaveError.JobID = %v, want %v", saveErr.JobID, tt.wantJobID)
+			}
+			if err != nil {
+				return
+			}
+			if got.Original != "http://archive.org/" || got.Timestamp.Format("20060102150405") != "20060102150405" || got.StatusCode != 200 {
+				t.Errorf("CDXAPI.SaveContext() = %+v", got)
+			}
+			if body, err := ioutil.ReadAll(got.Data); err != nil || string(body) != "snapshot" {
+				t.Errorf("CDXResult.Data = %q, %v, want snapshot", body, err)
+			}
+		})
+	}
+}
@@ -3,21 +3,27 @@ package simplewayback
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	neturl "net/url"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	cdxURL  = "https://web.archive.org/cdx/search/cdx?"
-	dataURL = "http://web.archive.org/web"
+	cdxURL       = "https://web.archive.org/cdx/search/cdx"
+	dataURL      = "https://web.archive.org/web"
+	availableURL = "https://archive.org/wayback/available"
+	timeMapURL   = "https://web.archive.org/web/timemap/link"
+	saveURL      = "https://web.archive.org/save"
+	userAgent    = "simplewayback/0.1 (https://github.com/rhelmke/simplewayback)"
 )
 
 type matchType int
@@ -26,6 +32,10 @@ type outputFormat int
 
 type field int
 
+type sortOrder int
+
+type replayMode int
+
 // Matchtypes
 const (
 	// MatchTypeExact instructs the simplewayback package to return results matching exactly example.org/example.html
@@ -46,6 +56,32 @@ const (
 	OutputFormatCDX
 )
 
+// Sort Orders
+const (
+	// SortDefault returns results in ascending order of urlkey and timestamp
+	SortDefault sortOrder = iota
+	// SortReverse returns results in descending order, i.e. the latest captures first
+	SortReverse
+	// SortClosest returns results ordered by their distance to a given timestamp
+	SortClosest
+)
+
+// Replay Modes
+const (
+	// ReplayModeDefault fetches the replay page, including the Wayback toolbar and rewritten links
+	ReplayModeDefault replayMode = iota
+	// ReplayModeIdentity (id_) fetches the byte-identical archived payload without any rewriting
+	ReplayModeIdentity
+	// ReplayModeIframe (if_) fetches the rewritten page without the Wayback toolbar
+	ReplayModeIframe
+	// ReplayModeImage (im_) fetches the snapshot as image
+	ReplayModeImage
+	// ReplayModeJS (js_) fetches the snapshot as rewritten JavaScript
+	ReplayModeJS
+	// ReplayModeCSS (cs_) fetches the snapshot as rewritten stylesheet
+	ReplayModeCSS
+)
+
 // Errors
 var (
 	// ErrorInvalidMatchType...
@@ -58,6 +94,29 @@ var (
 	ErrorPaginationResumption = errors.New("simplewayback: Pagination and Resumption Keys can not be enabled at the same time")
 	ErrorInvalidScheme        = errors.New("simplewayback: The provided URL must use 'http', 'https' or '' as scheme")
 	ErrorBadResponse          = errors.New("simplewayback: Bad Response from Wayback Machine API (!200)")
+	ErrorRateLimited          = errors.New("simplewayback: Rate limited by Wayback Machine API (429)")
+	ErrorBlocked              = errors.New("simplewayback: Blocked by Wayback Machine API (robots.txt or exclusion)")
+	ErrorNotFound             = errors.New("simplewayback: Not found by Wayback Machine API (404)")
+	ErrorServerError          = errors.New("simplewayback: Server error in Wayback Machine API (5xx)")
+	ErrorInvalidHTTPClient    = errors.New("simplewayback: HTTP client and transport must not be nil")
+	ErrorInvalidEndpoint      = errors.New("simplewayback: Endpoints must be absolute 'http' or 'https' URLs")
+	ErrorMalformedResult      = errors.New("simplewayback: Malformed result in CDX API response")
+	ErrorInvalidRetryPolicy   = errors.New("simplewayback: Invalid retry policy")
+	ErrorInvalidRateLimit     = errors.New("simplewayback: Rate limits must be > 0")
+	ErrorInvalidSortOrder     = errors.New("simplewayback: Invalid sort order")
+	ErrorInvalidClosest       = errors.New("simplewayback: SortClosest requires a closest timestamp")
+	ErrorSortPagination       = errors.New("simplewayback: Pagination can not be combined with SortReverse or SortClosest")
+	ErrorSortResumption       = errors.New("simplewayback: Resumption Keys can not be combined with SortClosest")
+	ErrorFastLatestResumption = errors.New("simplewayback: Resumption Keys can not be combined with fastLatest")
+	ErrorInvalidReplayMode    = errors.New("simplewayback: Invalid replay mode")
+	ErrorDigestMismatch       = errors.New("simplewayback: Payload digest mismatch")
+	ErrorMissingDigest        = errors.New("simplewayback: Result has no digest to verify against")
+	ErrorClosed               = errors.New("simplewayback: Read on closed reader")
+	ErrorRedirect             = errors.New("simplewayback: Snapshot redirects to another location")
+	ErrorSaveFailed           = errors.New("simplewayback: Save Page Now capture failed")
+	ErrorMalformedWARC        = errors.New("simplewayback: Malformed WARC record")
+	ErrorInvalidWARCDir       = errors.New("simplewayback: WARC directory is not a directory")
+	ErrorInvalidWARCFilename  = errors.New("simplewayback: WARC filename is outside of the WARC directory")
 )
 
 // RegexFields
@@ -69,8 +128,36 @@ const (
 	FieldStatuscode
 	FieldDigest
 	FieldLength
+	// FieldRedirect is the redirect target of a capture (pywb, OpenWayback)
+	FieldRedirect
+	// FieldRobotFlags holds the robot meta tag flags of a capture (pywb, OpenWayback)
+	FieldRobotFlags
+	// FieldOffset is the offset of the capture in its (compressed) WARC file
+	FieldOffset
+	// FieldFilename is the name of the WARC file holding the capture
+	FieldFilename
 )
 
+// counter fields are appended to the results by showDupeCount, showSkipCount and
+// lastSkipTimestamp. They can neither be filtered nor selected.
+const (
+	fieldDupeCount field = iota + 100
+	fieldSkipCount
+	fieldEndTimestamp
+)
+
+var counterFields = map[field]string{
+	fieldDupeCount:    "dupecount",
+	fieldSkipCount:    "skipcount",
+	fieldEndTimestamp: "endtimestamp",
+}
+
+// fieldUnknown marks columns of a CDX API response that are not supported
+const fieldUnknown field = -1
+
+// defaultFields lists the fields of a CDX API response in order, if no field list is requested
+var defaultFields = []field{FieldURLKey, FieldTimestamp, FieldOriginal, FieldMimetype, FieldStatuscode, FieldDigest, FieldLength}
+
 var fields = map[field]string{
 	FieldURLKey:     "urlkey",
 	FieldTimestamp:  "timestamp",
@@ -79,6 +166,25 @@ var fields = map[field]string{
 	FieldStatuscode: "statuscode",
 	FieldDigest:     "digest",
 	FieldLength:     "length",
+	FieldRedirect:   "redirect",
+	FieldRobotFlags: "robotflags",
+	FieldOffset:     "offset",
+	FieldFilename:   "filename",
+}
+
+// fieldByName returns the field called name or fieldUnknown
+func fieldByName(name string) field {
+	for fld, fldName := range fields {
+		if fldName == name {
+			return fld
+		}
+	}
+	for fld, fldName := range counterFields {
+		if fldName == name {
+			return fld
+		}
+	}
+	return fieldUnknown
 }
 
 var matchTypes = map[matchType]string{
@@ -88,6 +194,21 @@ var matchTypes = map[matchType]string{
 	MatchTypeDomain: "domain",
 }
 
+var sortOrders = map[sortOrder]string{
+	SortDefault: "",
+	SortReverse: "reverse",
+	SortClosest: "closest",
+}
+
+var replayModes = map[replayMode]string{
+	ReplayModeDefault:  "",
+	ReplayModeIdentity: "id_",
+	ReplayModeIframe:   "if_",
+	ReplayModeImage:    "im_",
+	ReplayModeJS:       "js_",
+	ReplayModeCSS:      "cs_",
+}
+
 var outputFormats = map[outputFormat]string{
 	OutputFormatJSON: "json",
 	OutputFormatCDX:  "cdx",
@@ -103,6 +224,20 @@ type CDXAPI struct {
 	usePagination    bool
 	page             int
 	apiKey           string
+	apiSecret        string
+	client           *http.Client
+	retry            RetryPolicy
+	limiter          *RateLimiter
+	cdxEndpoint      string
+	replayEndpoint   string
+	availEndpoint    string
+	timeMapEndpoint  string
+	timeGateEndpoint string
+	saveEndpoint     string
+	savePoll         time.Duration
+	warcDir          string
+	replayMode       replayMode
+	followRedirects  bool
 	urlBuf           *bytes.Buffer
 }
 
@@ -126,6 +261,146 @@ func (cdx *CDXAPI) APIKey() string {
 	return cdx.apiKey
 }
 
+// SetAPISecret sets an optional API secret. Together with the API key it authenticates Save Page
+// Now requests, see https://archive.org/account/s3.php.
+func (cdx *CDXAPI) SetAPISecret(apiSecret string) error {
+	cdx.apiSecret = apiSecret
+	return nil
+}
+
+// APISecret getter
+func (cdx *CDXAPI) APISecret() string {
+	return cdx.apiSecret
+}
+
+// SetHTTPClient sets the client used for CDX searches and snapshot downloads. This allows
+// for custom timeouts, proxies, TLS settings and connection pooling.
+func (cdx *CDXAPI) SetHTTPClient(client *http.Client) error {
+	if client == nil {
+		return ErrorInvalidHTTPClient
+	}
+	cdx.client = client
+	return nil
+}
+
+// HTTPClient getter
+func (cdx *CDXAPI) HTTPClient() *http.Client {
+	return cdx.httpClient()
+}
+
+// SetTransport sets the http.RoundTripper of the client used for CDX searches and snapshot
+// downloads. All other settings of the current client are kept.
+func (cdx *CDXAPI) SetTransport(transport http.RoundTripper) error {
+	if transport == nil {
+		return ErrorInvalidHTTPClient
+	}
+	client := *cdx.httpClient()
+	client.Transport = transport
+	cdx.client = &client
+	return nil
+}
+
+// ResetHTTPClient resets the HTTP client (default: http.DefaultClient)
+func (cdx *CDXAPI) ResetHTTPClient() {
+	cdx.client = nil
+}
+
+// httpClient returns the client to use for requests issued on behalf of cdx.
+// It is safe to call on a nil *CDXAPI.
+func (cdx *CDXAPI) httpClient() *http.Client {
+	if cdx == nil || cdx.client == nil {
+		return http.DefaultClient
+	}
+	return cdx.client
+}
+
+// SetCDXEndpoint sets the base URL of the CDX search API, e.g. "http://localhost:8080/coll/cdx"
+// for a pywb collection. This allows querying any CDX-server-compatible archive.
+func (cdx *CDXAPI) SetCDXEndpoint(endpoint string) error {
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	cdx.cdxEndpoint = endpoint
+	return nil
+}
+
+// CDXEndpoint getter
+func (cdx *CDXAPI) CDXEndpoint() string {
+	if cdx == nil || cdx.cdxEndpoint == "" {
+		return cdxURL
+	}
+	return cdx.cdxEndpoint
+}
+
+// ResetCDXEndpoint resets the CDX search endpoint (default: https://web.archive.org/cdx/search/cdx)
+func (cdx *CDXAPI) ResetCDXEndpoint() {
+	cdx.cdxEndpoint = ""
+}
+
+// SetReplayEndpoint sets the base URL snapshots are fetched from. Snapshots are requested as
+// <endpoint>/<timestamp>/<original>, e.g. "http://localhost:8080/coll" for a pywb collection.
+func (cdx *CDXAPI) SetReplayEndpoint(endpoint string) error {
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	cdx.replayEndpoint = strings.TrimRight(endpoint, "/")
+	return nil
+}
+
+// ReplayEndpoint getter
+func (cdx *CDXAPI) ReplayEndpoint() string {
+	if cdx == nil || cdx.replayEndpoint == "" {
+		return dataURL
+	}
+	return cdx.replayEndpoint
+}
+
+// ResetReplayEndpoint resets the replay endpoint (default: https://web.archive.org/web)
+func (cdx *CDXAPI) ResetReplayEndpoint() {
+	cdx.replayEndpoint = ""
+}
+
+// SetReplayMode sets the replay mode used to fetch the snapshot data of results. Use
+// ReplayModeIdentity to get the original archived bytes, e.g. to compare them against
+// CDXResult.Digest.
+func (cdx *CDXAPI) SetReplayMode(mode replayMode) error {
+	if _, ok := replayModes[mode]; !ok {
+		return ErrorInvalidReplayMode
+	}
+	cdx.replayMode = mode
+	return nil
+}
+
+// ReplayMode getter
+func (cdx *CDXAPI) ReplayMode() int {
+	if cdx == nil {
+		return int(ReplayModeDefault)
+	}
+	return int(cdx.replayMode)
+}
+
+// ResetReplayMode resets the replay mode (default: ReplayModeDefault)
+func (cdx *CDXAPI) ResetReplayMode() {
+	cdx.replayMode = ReplayModeDefault
+}
+
+// snapshotURL builds the replay URL of a capture: <endpoint>/<timestamp><mode>/<original>
+func (cdx *CDXAPI) snapshotURL(timestamp time.Time, original string, mode replayMode) string {
+	return fmt.Sprintf("%s/%s%s/%s", cdx.ReplayEndpoint(), timestamp.Format("20060102150405"), replayModes[mode], original)
+}
+
+// validateEndpoint checks whether endpoint is an absolute http(s) URL
+func validateEndpoint(endpoint string) error {
+	parsed, err := neturl.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if !(parsed.Scheme == "http" || parsed.Scheme == "https") || parsed.Host == "" {
+		return ErrorInvalidEndpoint
+	}
+	return nil
+}
+
 // SetMatchType where mType = MatchTypeExact | MatchTypePrefix | MatchTypeHost | MatchTypeDomain
 func (cdx *CDXAPI) SetMatchType(mType matchType) error {
 	if _, ok := matchTypes[mType]; !ok {
@@ -318,6 +593,113 @@ func (cdx *CDXAPI) ResetCollapsing() {
 	cdx.collapsingKeys = []string{}
 }
 
+// SetFields selects the fields returned by the CDX API (fl=). Results only carry the
+// selected fields; fetching snapshot data requires FieldTimestamp and FieldOriginal.
+func (cdx *CDXAPI) SetFields(flds ...field) error {
+	if len(flds) == 0 {
+		return ErrorInvalidField
+	}
+	names := make([]string, len(flds))
+	for i, fld := range flds {
+		if _, ok := fields[fld]; !ok {
+			return ErrorInvalidField
+		}
+		names[i] = fields[fld]
+	}
+	cdx.params.Set("fl", strings.Join(names, ","))
+	return nil
+}
+
+// Fields getter
+func (cdx *CDXAPI) Fields() []field {
+	fl := cdx.params.Get("fl")
+	if fl == "" {
+		// return a copy, so callers can't change the defaults
+		return append([]field(nil), defaultFields...)
+	}
+	names := strings.Split(fl, ",")
+	flds := make([]field, len(names))
+	for i, name := range names {
+		flds[i] = fieldByName(name)
+	}
+	return flds
+}
+
+// ResetFields resets the field selection (default: urlkey timestamp original mimetype statuscode digest length)
+func (cdx *CDXAPI) ResetFields() {
+	cdx.params.Del("fl")
+}
+
+// responseFields returns the columns of a result row in order, including counter fields
+func (cdx *CDXAPI) responseFields() []field {
+	flds := cdx.Fields()
+	if !cdx.ShowDupeCount() && !cdx.ShowSkipCount() {
+		return flds
+	}
+	flds = append([]field{}, flds...)
+	if cdx.ShowDupeCount() {
+		flds = append(flds, fieldDupeCount)
+	}
+	if cdx.ShowSkipCount() {
+		flds = append(flds, fieldSkipCount)
+		if cdx.LastSkipTimestamp() {
+			flds = append(flds, fieldEndTimestamp)
+		}
+	}
+	return flds
+}
+
+// SetShowDupeCount annotates each result with the number of captures sharing its digest
+// (CDXResult.DupeCount). This is most useful together with collapsing by FieldDigest.
+func (cdx *CDXAPI) SetShowDupeCount(enabled bool) error {
+	if enabled {
+		cdx.params.Set("showDupeCount", "true")
+	} else {
+		cdx.params.Del("showDupeCount")
+	}
+	return nil
+}
+
+// ShowDupeCount getter
+func (cdx *CDXAPI) ShowDupeCount() bool {
+	return cdx.params.Get("showDupeCount") == "true"
+}
+
+// ResetShowDupeCount resets the dupe count (default: false)
+func (cdx *CDXAPI) ResetShowDupeCount() {
+	cdx.params.Del("showDupeCount")
+}
+
+// SetShowSkipCount annotates each collapsed result with the number of captures that have been
+// skipped in its favour (CDXResult.SkipCount). If lastSkipTimestamp is true, the timestamp of
+// the last skipped capture is returned as well (CDXResult.EndTimestamp).
+func (cdx *CDXAPI) SetShowSkipCount(enabled bool, lastSkipTimestamp bool) error {
+	cdx.params.Del("showSkipCount")
+	cdx.params.Del("lastSkipTimestamp")
+	if enabled {
+		cdx.params.Set("showSkipCount", "true")
+		if lastSkipTimestamp {
+			cdx.params.Set("lastSkipTimestamp", "true")
+		}
+	}
+	return nil
+}
+
+// ShowSkipCount getter
+func (cdx *CDXAPI) ShowSkipCount() bool {
+	return cdx.params.Get("showSkipCount") == "true"
+}
+
+// LastSkipTimestamp getter
+func (cdx *CDXAPI) LastSkipTimestamp() bool {
+	return cdx.params.Get("lastSkipTimestamp") == "true"
+}
+
+// ResetShowSkipCount resets the skip count (default: showSkipCount=false, lastSkipTimestamp=false)
+func (cdx *CDXAPI) ResetShowSkipCount() {
+	cdx.SetShowSkipCount(false, false)
+}
+
 // SetGzip for gzipped response from archive.org
 func (cdx *CDXAPI) SetGzip(enabled bool) error {
 	if enabled {
@@ -370,6 +752,12 @@ func (cdx *CDXAPI) SetResumptionKey(enabled bool, key string) error {
 	if cdx.usePagination {
 		return ErrorPaginationResumption
 	}
+	if enabled && cdx.Sort() == int(SortClosest) {
+		return ErrorSortResumption
+	}
+	if enabled && cdx.FastLatest() {
+		return ErrorFastLatestResumption
+	}
 	cdx.useResumptionKey = enabled
 	if enabled {
 		cdx.params.Set("resumeKey", key)
@@ -401,6 +789,9 @@ func (cdx *CDXAPI) SetPagination(enabled bool, page int) error {
 	if cdx.useResumptionKey {
 		return ErrorPaginationResumption
 	}
+	if enabled && cdx.Sort() != int(SortDefault) {
+		return ErrorSortPagination
+	}
 	if page < 0 {
 		return ErrorInvalidNumber
 	}
@@ -430,12 +821,147 @@ func (cdx *CDXAPI) ResetPagination() {
 	cdx.SetPagination(false, 0)
 }
 
+// SetSort sets the sort order of the results. closest is only used with SortClosest, which
+// returns the captures nearest to closest first; combine it with SetLimit to get the N nearest
+// snapshots. SortReverse and SortClosest can not be combined with pagination, SortClosest can
+// not be combined with resumption keys either.
+func (cdx *CDXAPI) SetSort(order sortOrder, closest time.Time) error {
+	if _, ok := sortOrders[order]; !ok {
+		return ErrorInvalidSortOrder
+	}
+	if order != SortDefault && cdx.usePagination {
+		return ErrorSortPagination
+	}
+	if order == SortClosest && cdx.useResumptionKey {
+		return ErrorSortResumption
+	}
+	if order == SortClosest && closest.IsZero() {
+		return ErrorInvalidClosest
+	}
+	cdx.ResetSort()
+	if order != SortDefault {
+		cdx.params.Set("sort", sortOrders[order])
+	}
+	if order == SortClosest {
+		cdx.params.Set("closest", closest.Format("20060102150405"))
+	}
+	return nil
+}
+
+// Sort getter
+func (cdx *CDXAPI) Sort() int {
+	switch cdx.params.Get("sort") {
+	case sortOrders[SortReverse]:
+		return int(SortReverse)
+	case sortOrders[SortClosest]:
+		return int(SortClosest)
+	}
+	return int(SortDefault)
+}
+
+// Closest getter
+func (cdx *CDXAPI) Closest() time.Time {
+	closest, err := time.Parse("20060102150405", cdx.params.Get("closest"))
+	if err != nil {
+		return time.Time{}
+	}
+	return closest
+}
+
+// ResetSort resets the sort order (default: SortDefault)
+func (cdx *CDXAPI) ResetSort() {
+	cdx.params.Del("sort")
+	cdx.params.Del("closest")
+}
+
+// SetFastLatest enables the fastLatest mode, which quickly returns the latest captures. If a
+// limit N is set, the latest N captures are returned (sent as limit=-N). fastLatest can not be
+// combined with resumption keys, as the negative limit breaks batching.
+func (cdx *CDXAPI) SetFastLatest(enabled bool) error {
+	if enabled && cdx.useResumptionKey {
+		return ErrorFastLatestResumption
+	}
+	if enabled {
+		cdx.params.Set("fastLatest", "true")
+	} else {
+		cdx.params.Del("fastLatest")
+	}
+	return nil
+}
+
+// FastLatest getter
+func (cdx *CDXAPI) FastLatest() bool {
+	return cdx.params.Get("fastLatest") == "true"
+}
+
+// ResetFastLatest resets the fastLatest mode (default: false)
+func (cdx *CDXAPI) ResetFastLatest() {
+	cdx.params.Del("fastLatest")
+}
+
+// SetPageSize sets the number of index blocks per page used by the pagination mode
+func (cdx *CDXAPI) SetPageSize(size int) error {
+	if size <= 0 {
+		return ErrorInvalidNumber
+	}
+	cdx.params.Set("pageSize", strconv.Itoa(size))
+	return nil
+}
+
+// PageSize getter
+func (cdx *CDXAPI) PageSize() int {
+	size, err := strconv.Atoi(cdx.params.Get("pageSize"))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// ResetPageSize resets the page size (default: chosen by the CDX server)
+func (cdx *CDXAPI) ResetPageSize() {
+	cdx.params.Del("pageSize")
+}
+
+// NumPages asks the CDX API for the number of pages of the current query (showNumPages=true)
+func (cdx *CDXAPI) NumPages() (int, error) {
+	return cdx.NumPagesContext(context.Background())
+}
+
+// NumPagesContext is like NumPages, but the request is bound to ctx
+func (cdx *CDXAPI) NumPagesContext(ctx context.Context) (int, error) {
+	if cdx.useResumptionKey {
+		return 0, ErrorPaginationResumption
+	}
+	cdx.params.Set("showNumPages", "true")
+	defer cdx.params.Del("showNumPages")
+	qry, err := cdx.RawPerformContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer qry.Close()
+	body, err := ioutil.ReadAll(qry)
+	if err != nil {
+		return 0, err
+	}
+	pages, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil || pages < 0 {
+		return 0, ErrorMalformedResult
+	}
+	return pages, nil
+}
+
 func (cdx *CDXAPI) buildURL(urlDst *bytes.Buffer) error {
 	if cdx.params.Get("url") == "" {
 		return ErrorInvalidURL
 	}
 	urlDst.Reset()
-	urlDst.WriteString(cdxURL)
+	endpoint := cdx.CDXEndpoint()
+	urlDst.WriteString(endpoint)
+	if strings.Contains(endpoint, "?") {
+		urlDst.WriteString("&")
+	} else {
+		urlDst.WriteString("?")
+	}
 
 	// this is why setting collapse- and regex-filters is not straightforward.
 	// The CDX-API requires that "collapse=" and "filter=" are placed multiple times
@@ -443,7 +969,16 @@ func (cdx *CDXAPI) buildURL(urlDst *bytes.Buffer) error {
 	// Adding multiple filters of the same type results in overwriting the previous
 	// filter. So we need to hold unique Keys in url.Values that will be replaced
 	// using following lines of code.
-	encoded := cdx.params.Encode()
+	params := *cdx.params
+	// the latest N captures are requested using a negative limit
+	if cdx.FastLatest() && cdx.Limit() > 0 {
+		params = neturl.Values{}
+		for key, values := range *cdx.params {
+			params[key] = values
+		}
+		params.Set("limit", strconv.Itoa(-cdx.Limit()))
+	}
+	encoded := params.Encode()
 	if len(cdx.collapsingKeys) > 0 {
 		encoded = regexp.MustCompile(`(collapse\d+)=`).ReplaceAllString(encoded, "collapse=")
 	}
@@ -454,34 +989,78 @@ func (cdx *CDXAPI) buildURL(urlDst *bytes.Buffer) error {
 	return nil
 }
 
-// CDXRawQuery implements the reader interface to raw read a single search result
+// CDXRawQuery implements the reader interface to raw read a single search result.
+// The underlying connection is released once the response has been read to EOF, a read
+// fails or Close is called. Call Close if the response is not read completely.
 type CDXRawQuery struct {
 	resp *http.Response
+	err  error
 }
 
 // Read interface implementation for CDXAPI
 func (qry *CDXRawQuery) Read(p []byte) (int, error) {
-	return qry.resp.Body.Read(p)
+	if qry.err != nil {
+		return 0, qry.err
+	}
+	n, err := qry.resp.Body.Read(p)
+	if err != nil {
+		// release the connection as soon as the response has been consumed
+		qry.err = err
+		qry.resp.Body.Close()
+	}
+	return n, err
+}
+
+// Close releases the underlying connection and makes subsequent reads fail with ErrorClosed.
+// It is safe to call Close multiple times.
+func (qry *CDXRawQuery) Close() error {
+	if qry.err == ErrorClosed {
+		return nil
+	}
+	qry.err = ErrorClosed
+	return qry.resp.Body.Close()
 }
 
 // RawPerform queries the CDX API and returns a CDXRawQuery that can be read using the Reader interface
 func (cdx *CDXAPI) RawPerform() (*CDXRawQuery, error) {
+	return cdx.RawPerformContext(context.Background())
+}
+
+// RawPerformContext is like RawPerform, but the request is bound to ctx. Cancelling ctx or
+// exceeding its deadline aborts the request and any subsequent Read on the returned CDXRawQuery.
+func (cdx *CDXAPI) RawPerformContext(ctx context.Context) (*CDXRawQuery, error) {
 	if err := cdx.buildURL(cdx.urlBuf); err != nil {
 		return nil, err
 	}
-	client := http.Client{}
-	req, err := http.NewRequest("GET", cdx.urlBuf.String(), nil)
+	return cdx.query(ctx, cdx.urlBuf.String())
+}
+
+// query performs a search request on an already built CDX API URL. In contrast to
+// RawPerformContext, it does not touch the query parameters of cdx and is therefore
+// safe for concurrent use.
+func (cdx *CDXAPI) query(ctx context.Context, url string) (*CDXRawQuery, error) {
+	return cdx.get(ctx, url, true)
+}
+
+// get performs a GET request and returns the response if it succeeded. The API key is only
+// sent if auth is set, i.e. for CDX searches, as other endpoints may belong to third parties.
+func (cdx *CDXAPI) get(ctx context.Context, url string, auth bool) (*CDXRawQuery, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "simplewayback/0.1 (https://github.com/rhelmke/simplewayback)")
-	if cdx.apiKey != "" {
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", userAgent)
+	if auth && cdx.apiKey != "" {
 		req.AddCookie(&http.Cookie{Name: "cdx-auth-token", Value: cdx.apiKey})
 	}
-	resp, err := client.Do(req)
+	resp, err := cdx.do(req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
 	return &CDXRawQuery{resp: resp}, nil
 }
 
@@ -494,101 +1073,221 @@ type CDXResult struct {
 	StatusCode int       `json:"status_code"`
 	Digest     string    `json:"digest"`
 	Length     int       `json:"length"`
-	Data       io.Reader `json:"-"`
+	Redirect   string    `json:"redirect,omitempty"`
+	RobotFlags string    `json:"robot_flags,omitempty"`
+	Offset     int64     `json:"offset,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	// DupeCount, SkipCount and EndTimestamp are only set if requested using
+	// SetShowDupeCount and SetShowSkipCount
+	DupeCount    int       `json:"dupe_count,omitempty"`
+	SkipCount    int       `json:"skip_count,omitempty"`
+	EndTimestamp time.Time `json:"end_timestamp"`
+	// Data fetches the snapshot of the result. The request is sent on the first Read and
+	// the connection is released on EOF, on error or on Close. Call Close if Data is not
+	// read to EOF.
+	Data io.ReadCloser `json:"-"`
+	cdx  *CDXAPI
+	// url is the URI-M of mementos, see Memento
+	url string
+}
+
+// DataContext returns a new reader for the snapshot data of r. In contrast to r.Data,
+// the request performed by the returned reader is bound to ctx.
+func (r CDXResult) DataContext(ctx context.Context) io.ReadCloser {
+	return r.reader(ctx, replayMode(r.cdx.ReplayMode()))
+}
+
+// reader returns a reader for the snapshot data of r using the given replay mode. If a WARC
+// directory is set and r refers to a WARC file, the payload is read from that file instead.
+func (r CDXResult) reader(ctx context.Context, mode replayMode) *cdxResultReader {
+	dr := &cdxResultReader{cdx: r.cdx, ctx: ctx, url: r.snapshotURL(mode), mode: mode}
+	if dir := r.cdx.WARCDir(); dir != "" && r.Filename != "" {
+		// the filename is taken from the CDX server and must not escape dir
+		path := filepath.Join(dir, filepath.FromSlash(r.Filename))
+		if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			dr.err = ErrorInvalidWARCFilename
+			return dr
+		}
+		dr.warc, dr.offset, dr.length = path, r.Offset, int64(r.Length)
+	}
+	return dr
+}
+
+// snapshotURL returns the URL the snapshot of r is fetched from using the given replay mode.
+// Mementos are fetched from their URI-M, which only honors the replay mode if it points into
+// the replay endpoint.
+func (r CDXResult) snapshotURL(mode replayMode) string {
+	if r.url == "" {
+		return r.cdx.snapshotURL(r.Timestamp, r.Original, mode)
+	}
+	if u, err := neturl.Parse(r.url); err == nil && r.cdx.isReplayURL(u) {
+		if loc := replayTimestamp.FindStringSubmatchIndex(r.url); loc != nil {
+			return r.url[:loc[0]] + "/" + r.url[loc[2]:loc[3]] + replayModes[mode] + "/" + r.url[loc[1]:]
+		}
+	}
+	return r.url
 }
 
 // CDXResultReader can be used to perform a request to the wayback machine and
 // fetch the snapshot data of a specific CDXResult.
 type cdxResultReader struct {
-	resp      *http.Response
-	original  string
-	timestamp time.Time
-	eof       bool
+	cdx    *CDXAPI
+	ctx    context.Context
+	body   io.ReadCloser
+	url    string
+	mode   replayMode
+	warc   string
+	offset int64
+	length int64
+	err    error
 }
 
 // Read implements the Reader interface for CDXResultReader
 func (dr *cdxResultReader) Read(p []byte) (int, error) {
-	if dr.eof {
-		return 0, io.EOF
+	if dr.err != nil {
+		return 0, dr.err
 	}
-	if dr.resp == nil {
-		client := http.Client{}
-		req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s", dataURL, dr.timestamp.Format("20060102150405"), dr.original), nil)
-		if err != nil {
-			return 0, err
-		}
-		req.Header.Set("User-Agent", "simplewayback/0.1 (https://github.com/rhelmke/simplewayback)")
-		req.Header.Del("Accept-Encoding")
-		req.Header.Set("Accept", "*/*")
-		dr.resp, err = client.Do(req)
-		if err != nil {
-			dr.eof = true
+	if dr.body == nil {
+		if err := dr.open(); err != nil {
+			dr.err = err
 			return 0, err
 		}
 	}
-	return dr.resp.Body.Read(p)
+	n, err := dr.body.Read(p)
+	if err != nil {
+		// release the connection as soon as the snapshot has been consumed
+		dr.err = err
+		dr.body.Close()
+	}
+	return n, err
+}
+
+// open requests the snapshot or opens the WARC record holding it
+func (dr *cdxResultReader) open() error {
+	if dr.warc != "" {
+		body, err := OpenWARCPayload(dr.warc, dr.offset, dr.length)
+		dr.body = body
+		return err
+	}
+	resp, err := dr.cdx.fetchSnapshot(dr.ctx, dr.url, dr.mode)
+	if err != nil {
+		return err
+	}
+	dr.body = resp.Body
+	return nil
+}
+
+// Close implements the Closer interface for CDXResultReader. It releases the connection and
+// makes subsequent reads fail with ErrorClosed. It is safe to call Close multiple times.
+func (dr *cdxResultReader) Close() error {
+	if dr.err == ErrorClosed {
+		return nil
+	}
+	dr.err = ErrorClosed
+	if dr.body != nil {
+		return dr.body.Close()
+	}
+	return nil
 }
 
 // Perform queries the CDX API and returns a set of results
 func (cdx *CDXAPI) Perform() ([]CDXResult, error) {
-	isJSON := cdx.params.Get("output") == "json"
-	// it's nice to have cdx and json support. But I don't think it's necessary
-	// to implement parsing support for both output formats when this method
-	// returns a []CDXResult-Type either ways. So we force json.
-	if !isJSON {
-		cdx.params.Set("output", "json")
-	}
-	qry, err := cdx.RawPerform()
+	return cdx.PerformContext(context.Background())
+}
+
+// PerformContext is like Perform, but the query is bound to ctx. The Data readers of the
+// returned results inherit ctx as well; use CDXResult.DataContext to fetch snapshots with
+// a different context.
+func (cdx *CDXAPI) PerformContext(ctx context.Context) ([]CDXResult, error) {
+	it, err := cdx.IterateContext(ctx)
 	if err != nil {
 		return []CDXResult{}, err
 	}
-	qryRes, err := ioutil.ReadAll(qry)
-	if err != nil {
-		return []CDXResult{}, err
+	defer it.Close()
+	result := []CDXResult{}
+	for it.Next() {
+		result = append(result, it.Result())
 	}
-	splitBuf := [][]string{}
-	if err := json.Unmarshal(qryRes, &splitBuf); err != nil {
+	if err := it.Err(); err != nil {
 		return []CDXResult{}, err
 	}
-	result := []CDXResult{}
-	for i := 1; i < len(splitBuf); i++ {
-		// resumption key stuff
-		if len(splitBuf[i]) == 0 {
-			continue
-		}
-		// resumption key stuff
-		if len(splitBuf[i]) == 1 {
-			if cdx.ResumptionKeyEnabled() {
-				cdx.params.Set("resumeKey", splitBuf[i][0])
+	return result, nil
+}
+
+// ParseCDXLine parses a single line of a space delimited OutputFormatCDX response. flds lists
+// the fields of the line in order; if no fields are passed, the default 7-field format
+// (urlkey timestamp original mimetype statuscode digest length) is assumed.
+func ParseCDXLine(line string, flds ...field) (CDXResult, error) {
+	if len(flds) == 0 {
+		flds = defaultFields
+	}
+	var cdx *CDXAPI
+	return cdx.parseResult(context.Background(), strings.Fields(line), flds)
+}
+
+// parseResult converts a single row of a CDX response into a CDXResult. flds lists the
+// fields of row in order.
+func (cdx *CDXAPI) parseResult(ctx context.Context, row []string, flds []field) (CDXResult, error) {
+	if len(row) != len(flds) {
+		return CDXResult{}, ErrorMalformedResult
+	}
+	result := CDXResult{cdx: cdx}
+	for i, fld := range flds {
+		var err error
+		switch fld {
+		case FieldURLKey:
+			result.URLKey = row[i]
+		case FieldTimestamp:
+			result.Timestamp, err = time.Parse("20060102150405", row[i])
+		case FieldOriginal:
+			result.Original = row[i]
+		case FieldMimetype:
+			result.MimeType = row[i]
+		case FieldStatuscode:
+			result.StatusCode, err = parseNumber(row[i])
+		case FieldDigest:
+			result.Digest = row[i]
+		case FieldLength:
+			result.Length, err = parseNumber(row[i])
+		case FieldRedirect:
+			result.Redirect = parseString(row[i])
+		case FieldRobotFlags:
+			result.RobotFlags = parseString(row[i])
+		case FieldOffset:
+			if row[i] != "-" {
+				result.Offset, err = strconv.ParseInt(row[i], 10, 64)
+			}
+		case FieldFilename:
+			result.Filename = parseString(row[i])
+		case fieldDupeCount:
+			result.DupeCount, err = parseNumber(row[i])
+		case fieldSkipCount:
+			result.SkipCount, err = parseNumber(row[i])
+		case fieldEndTimestamp:
+			if row[i] != "-" {
+				result.EndTimestamp, err = time.Parse("20060102150405", row[i])
 			}
-			continue
-		}
-		// convert unknown status code to 0
-		if splitBuf[i][4] == "-" {
-			splitBuf[i][4] = "0"
-		}
-		if splitBuf[i][6] == "-" {
-			splitBuf[i][6] = "0"
-		}
-		// parse time
-		t, err := time.Parse("20060102150405", splitBuf[i][1])
-		if err != nil {
-			return []CDXResult{}, err
-		}
-		code, err := strconv.Atoi(splitBuf[i][4])
-		if err != nil {
-			return []CDXResult{}, err
 		}
-		ln, err := strconv.Atoi(splitBuf[i][6])
 		if err != nil {
-			return []CDXResult{}, err
+			return CDXResult{}, err
 		}
-		result = append(result, CDXResult{URLKey: splitBuf[i][0], Timestamp: t, Original: splitBuf[i][2], MimeType: splitBuf[i][3], StatusCode: code, Digest: splitBuf[i][5], Length: ln, Data: &cdxResultReader{original: splitBuf[i][2], timestamp: t}})
-	}
-	// act as changing the output never happened :D
-	if !isJSON {
-		cdx.params.Del("output")
 	}
+	result.Data = result.DataContext(ctx)
 	return result, nil
 }
 
+// parseString converts unknown values ("-") of a CDX field to ""
+func parseString(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parseNumber parses a numeric CDX field and converts unknown values ("-") to 0
+func parseNumber(s string) (int, error) {
+	if s == "-" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -2,6 +2,11 @@ package simplewayback
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	neturl "net/url"
 	"reflect"
 	"testing"
@@ -76,6 +81,289 @@ func TestCDXAPI_APIKey(t *testing.T) {
 	}
 }
 
+// rewriteTransport redirects all requests to a local test server
+type rewriteTransport struct {
+	target *neturl.URL
+}
+
+func (rt *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestServer serves a fixed CDX response and snapshot on the wayback paths
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/cdx/search/cdx" && r.URL.Query().Get("output") == "json":
+			w.Write([]byte(`[["urlkey","timestamp","original","mimetype","statuscode","digest","length"],` +
+				`["org,archive)/","20060102150405","http://archive.org/","text/html","200","AAAA","123"]]`))
+		case r.URL.Path == "/cdx/search/cdx":
+			w.Write([]byte("org,archive)/ 20060102150405 http://archive.org/ text/html 200 AAAA 123\n"))
+		case r.URL.Path == "/web/20060102150405/http://archive.org/":
+			w.Write([]byte("snapshot"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCDXAPI_SetHTTPClient(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	client := &http.Client{Timeout: time.Second}
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		client  *http.Client
+		wantErr bool
+	}{
+		{"ErrorInvalidHTTPClient", cdx, nil, true},
+		{"Valid client", cdx, client, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetHTTPClient(tt.client); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && tt.cdx.client != tt.client {
+				t.Errorf("CDXAPI.SetHTTPClient() did not set the client")
+			}
+		})
+	}
+}
+
+func TestCDXAPI_HTTPClient(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetHTTPClient(client)
+	tests := []struct {
+		name string
+		cdx  *CDXAPI
+		want *http.Client
+	}{
+		{"Default", cdx1, http.DefaultClient},
+		{"Custom", cdx2, client},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cdx.HTTPClient(); got != tt.want {
+				t.Errorf("CDXAPI.HTTPClient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_SetTransport(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetHTTPClient(&http.Client{Timeout: time.Second})
+	transport := &rewriteTransport{}
+	tests := []struct {
+		name      string
+		cdx       *CDXAPI
+		transport http.RoundTripper
+		wantErr   bool
+	}{
+		{"ErrorInvalidHTTPClient", cdx, nil, true},
+		{"Valid transport", cdx, transport, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetTransport(tt.transport); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetTransport() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && (tt.cdx.client.Transport != tt.transport || tt.cdx.client.Timeout != time.Second) {
+				t.Errorf("CDXAPI.SetTransport() did not keep the client settings")
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetHTTPClient(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetHTTPClient(&http.Client{})
+	tests := []struct {
+		name string
+		cdx  *CDXAPI
+	}{
+		{"Default Reset", cdx},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cdx.ResetHTTPClient()
+			if tt.cdx.HTTPClient() != http.DefaultClient {
+				t.Errorf("CDXAPI.ResetHTTPClient() didn't reset the client")
+			}
+		})
+	}
+}
+
+func TestCDXAPI_SetCDXEndpoint(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name     string
+		cdx      *CDXAPI
+		endpoint string
+		wantErr  bool
+	}{
+		{"ErrorInvalidEndpoint scheme", cdx, "ftp://localhost/cdx", true},
+		{"ErrorInvalidEndpoint relative", cdx, "/cdx", true},
+		{"url.Parse", cdx, "ü>äasdläüö:\\\\archive;org", true},
+		{"Valid endpoint", cdx, "http://localhost:8080/coll/cdx", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetCDXEndpoint(tt.endpoint); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetCDXEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_CDXEndpoint(t *testing.T) {
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetCDXEndpoint("http://localhost:8080/coll/cdx")
+	tests := []struct {
+		name string
+		cdx  *CDXAPI
+		want string
+	}{
+		{"Default", cdx1, "https://web.archive.org/cdx/search/cdx"},
+		{"Custom", cdx2, "http://localhost:8080/coll/cdx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cdx.CDXEndpoint(); got != tt.want {
+				t.Errorf("CDXAPI.CDXEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetCDXEndpoint(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetCDXEndpoint("http://localhost:8080/coll/cdx")
+	cdx.ResetCDXEndpoint()
+	if cdx.CDXEndpoint() != cdxURL {
+		t.Errorf("CDXAPI.ResetCDXEndpoint() didn't reset the endpoint")
+	}
+}
+
+func TestCDXAPI_SetReplayEndpoint(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name     string
+		cdx      *CDXAPI
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"ErrorInvalidEndpoint", cdx, "localhost/coll", "", true},
+		{"Trailing slash", cdx, "http://localhost:8080/coll/", "http://localhost:8080/coll", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetReplayEndpoint(tt.endpoint); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetReplayEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && tt.cdx.ReplayEndpoint() != tt.want {
+				t.Errorf("CDXAPI.SetReplayEndpoint() = %v, want %v", tt.cdx.ReplayEndpoint(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetReplayEndpoint(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetReplayEndpoint("http://localhost:8080/coll")
+	cdx.ResetReplayEndpoint()
+	if cdx.ReplayEndpoint() != dataURL {
+		t.Errorf("CDXAPI.ResetReplayEndpoint() didn't reset the endpoint")
+	}
+}
+
+func TestCDXAPI_SetReplayMode(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		mode    replayMode
+		wantErr bool
+	}{
+		{"ErrorInvalidReplayMode", cdx, -1, true},
+		{"Identity", cdx, ReplayModeIdentity, false},
+		{"CSS", cdx, ReplayModeCSS, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetReplayMode(tt.mode); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetReplayMode() error = %v, wantErr %v", err, tt.wantErr)
+			} else if !tt.wantErr && tt.cdx.ReplayMode() != int(tt.mode) {
+				t.Errorf("CDXAPI.ReplayMode() = %v, want %v", tt.cdx.ReplayMode(), tt.mode)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetReplayMode(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetReplayMode(ReplayModeIdentity)
+	cdx.ResetReplayMode()
+	if cdx.ReplayMode() != int(ReplayModeDefault) {
+		t.Errorf("CDXAPI.ResetReplayMode() didn't reset the replay mode")
+	}
+}
+
+func TestCDXAPI_snapshotURL(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name string
+		mode replayMode
+		want string
+	}{
+		{"Default", ReplayModeDefault, "https://web.archive.org/web/20060102150405/http://archive.org/"},
+		{"Identity", ReplayModeIdentity, "https://web.archive.org/web/20060102150405id_/http://archive.org/"},
+		{"Iframe", ReplayModeIframe, "https://web.archive.org/web/20060102150405if_/http://archive.org/"},
+		{"Image", ReplayModeImage, "https://web.archive.org/web/20060102150405im_/http://archive.org/"},
+		{"JS", ReplayModeJS, "https://web.archive.org/web/20060102150405js_/http://archive.org/"},
+		{"CSS", ReplayModeCSS, "https://web.archive.org/web/20060102150405cs_/http://archive.org/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cdx *CDXAPI
+			if got := cdx.snapshotURL(tm, "http://archive.org/", tt.mode); got != tt.want {
+				t.Errorf("CDXAPI.snapshotURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_PerformReplayMode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cdx":
+			w.Write([]byte("org,archive)/ 20060102150405 http://archive.org/ text/html 200 AAAA 123\n"))
+		case "/web/20060102150405id_/http://archive.org/":
+			w.Write([]byte("raw"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL + "/cdx")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	cdx.SetReplayMode(ReplayModeIdentity)
+	results, err := cdx.Perform()
+	if err != nil || len(results) != 1 {
+		t.Fatalf("CDXAPI.Perform() = %v, %v, want a single result", results, err)
+	}
+	for _, data := range []io.Reader{results[0].Data, results[0].DataContext(context.Background())} {
+		if got, err := ioutil.ReadAll(data); err != nil || string(got) != "raw" {
+			t.Errorf("CDXResult.Data = %q, %v, want raw", got, err)
+		}
+	}
+}
+
 func TestCDXAPI_SetMatchType(t *testing.T) {
 	type args struct {
 		mType matchType
@@ -337,6 +625,7 @@ func TestCDXAPI_AddRegexFilter(t *testing.T) {
 		{"CompileError", cdx, args{fld: FieldDigest, regex: "(?.*", negate: false}, true},
 		{"NoErrorNonNegate", cdx, args{fld: FieldDigest, regex: "XYA", negate: false}, false},
 		{"NoErrorNegate", cdx, args{fld: FieldDigest, regex: "XYA", negate: true}, false},
+		{"ExtendedField", cdx, args{fld: FieldFilename, regex: "^crawl-", negate: false}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -460,6 +749,7 @@ func TestCDXAPI_AddCollapsing(t *testing.T) {
 		{"ErrorInvalidNumber", cdx, args{FieldDigest, -1}, true},
 		{"No N", cdx, args{FieldDigest, 0}, false},
 		{"N", cdx, args{FieldDigest, 10}, false},
+		{"ExtendedField", cdx, args{FieldRedirect, 0}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -490,6 +780,131 @@ func TestCDXAPI_ResetCollapsing(t *testing.T) {
 	}
 }
 
+func TestCDXAPI_SetFields(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		flds    []field
+		want    string
+		wantErr bool
+	}{
+		{"ErrorInvalidField empty", cdx, nil, "", true},
+		{"ErrorInvalidField", cdx, []field{FieldDigest, -1}, "", true},
+		{"Valid fields", cdx, []field{FieldTimestamp, FieldOriginal}, "timestamp,original", false},
+		{"Extended fields", cdx, []field{FieldFilename, FieldOffset, FieldLength}, "filename,offset,length", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetFields(tt.flds...); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetFields() error = %v, wantErr %v", err, tt.wantErr)
+			} else if got := tt.cdx.params.Get("fl"); got != tt.want {
+				t.Errorf("CDXAPI.SetFields() fl = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_Fields(t *testing.T) {
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetFields(FieldDigest, FieldTimestamp)
+	tests := []struct {
+		name string
+		cdx  *CDXAPI
+		want []field
+	}{
+		{"Default", cdx1, defaultFields},
+		{"Selected", cdx2, []field{FieldDigest, FieldTimestamp}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cdx.Fields(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CDXAPI.Fields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	cdx1.Fields()[0] = FieldDigest
+	if got := cdx1.Fields(); got[0] != FieldURLKey || defaultFields[0] != FieldURLKey {
+		t.Errorf("CDXAPI.Fields() exposes the default fields: %v", got)
+	}
+}
+
+func TestCDXAPI_ResetFields(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetFields(FieldDigest)
+	cdx.ResetFields()
+	if cdx.params.Get("fl") != "" {
+		t.Errorf("CDXAPI.ResetFields() didn't reset the field selection")
+	}
+}
+
+func TestCDXAPI_ShowDupeCount(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{"Enabled", true},
+		{"Disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetShowDupeCount(tt.enabled); err != nil {
+				t.Errorf("CDXAPI.SetShowDupeCount() error = %v", err)
+			}
+			if got := cdx.ShowDupeCount(); got != tt.enabled {
+				t.Errorf("CDXAPI.ShowDupeCount() = %v, want %v", got, tt.enabled)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetShowDupeCount(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetShowDupeCount(true)
+	cdx.ResetShowDupeCount()
+	if cdx.ShowDupeCount() {
+		t.Errorf("CDXAPI.ResetShowDupeCount() didn't reset showDupeCount")
+	}
+}
+
+func TestCDXAPI_ShowSkipCount(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name              string
+		enabled           bool
+		lastSkipTimestamp bool
+		wantLast          bool
+	}{
+		{"Enabled", true, false, false},
+		{"Enabled with timestamp", true, true, true},
+		{"Disabled", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetShowSkipCount(tt.enabled, tt.lastSkipTimestamp); err != nil {
+				t.Errorf("CDXAPI.SetShowSkipCount() error = %v", err)
+			}
+			if got := cdx.ShowSkipCount(); got != tt.enabled {
+				t.Errorf("CDXAPI.ShowSkipCount() = %v, want %v", got, tt.enabled)
+			}
+			if got := cdx.LastSkipTimestamp(); got != tt.wantLast {
+				t.Errorf("CDXAPI.LastSkipTimestamp() = %v, want %v", got, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetShowSkipCount(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetShowSkipCount(true, true)
+	cdx.ResetShowSkipCount()
+	if cdx.ShowSkipCount() || cdx.LastSkipTimestamp() {
+		t.Errorf("CDXAPI.ResetShowSkipCount() didn't reset showSkipCount")
+	}
+}
+
 func TestCDXAPI_SetGzip(t *testing.T) {
 	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
 	type args struct {
@@ -792,6 +1207,253 @@ func TestCDXAPI_ResetPagination(t *testing.T) {
 	}
 }
 
+func TestCDXAPI_SetSort(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetPagination(true, 1)
+	cdx3 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx3.SetResumptionKey(true, "")
+	type args struct {
+		order   sortOrder
+		closest time.Time
+	}
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		args    args
+		wantErr bool
+	}{
+		{"ErrorInvalidSortOrder", cdx1, args{-1, tm}, true},
+		{"ErrorInvalidClosest", cdx1, args{SortClosest, time.Time{}}, true},
+		{"ErrorSortPagination", cdx2, args{SortReverse, tm}, true},
+		{"ErrorSortResumption", cdx3, args{SortClosest, tm}, true},
+		{"Reverse with resumption", cdx3, args{SortReverse, tm}, false},
+		{"Closest", cdx1, args{SortClosest, tm}, false},
+		{"Default with pagination", cdx2, args{SortDefault, time.Time{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetSort(tt.args.order, tt.args.closest); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetSort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_Sort(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetSort(SortReverse, time.Time{})
+	cdx3 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx3.SetSort(SortClosest, tm)
+	tests := []struct {
+		name        string
+		cdx         *CDXAPI
+		want        int
+		wantClosest time.Time
+	}{
+		{"Default", cdx1, int(SortDefault), time.Time{}},
+		{"Reverse", cdx2, int(SortReverse), time.Time{}},
+		{"Closest", cdx3, int(SortClosest), tm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cdx.Sort(); got != tt.want {
+				t.Errorf("CDXAPI.Sort() = %v, want %v", got, tt.want)
+			}
+			if got := tt.cdx.Closest(); !got.Equal(tt.wantClosest) {
+				t.Errorf("CDXAPI.Closest() = %v, want %v", got, tt.wantClosest)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetSort(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetSort(SortClosest, time.Now())
+	cdx.ResetSort()
+	if cdx.params.Get("sort") != "" || cdx.params.Get("closest") != "" {
+		t.Errorf("CDXAPI.ResetSort() didn't reset the sort order")
+	}
+	if err := cdx.SetPagination(true, 0); err != nil {
+		t.Errorf("CDXAPI.SetPagination() error = %v after ResetSort", err)
+	}
+}
+
+func TestCDXAPI_FastLatest(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{"Enabled", true},
+		{"Disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cdx.SetFastLatest(tt.enabled); err != nil {
+				t.Errorf("CDXAPI.SetFastLatest() error = %v", err)
+			}
+			if got := cdx.FastLatest(); got != tt.enabled {
+				t.Errorf("CDXAPI.FastLatest() = %v, want %v", got, tt.enabled)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_FastLatestResumption(t *testing.T) {
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx1.SetResumptionKey(true, "")
+	if err := cdx1.SetFastLatest(true); err != ErrorFastLatestResumption {
+		t.Errorf("CDXAPI.SetFastLatest() error = %v, want %v", err, ErrorFastLatestResumption)
+	}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetURL("archive.org")
+	cdx2.SetFastLatest(true)
+	if err := cdx2.SetResumptionKey(true, ""); err != ErrorFastLatestResumption {
+		t.Errorf("CDXAPI.SetResumptionKey() error = %v, want %v", err, ErrorFastLatestResumption)
+	}
+	if _, err := cdx2.IterateAll(10); err != ErrorFastLatestResumption {
+		t.Errorf("CDXAPI.IterateAll() error = %v, want %v", err, ErrorFastLatestResumption)
+	}
+	if err := cdx2.SetResumptionKey(false, ""); err != nil {
+		t.Errorf("CDXAPI.SetResumptionKey(false) error = %v", err)
+	}
+}
+
+func TestCDXAPI_ResetFastLatest(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetFastLatest(true)
+	cdx.ResetFastLatest()
+	if cdx.FastLatest() {
+		t.Errorf("CDXAPI.ResetFastLatest() didn't reset fastLatest")
+	}
+}
+
+func TestCDXAPI_buildURLSort(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	cdx1, _ := NewCDXAPI("archive.org")
+	cdx1.SetFastLatest(true)
+	cdx1.SetLimit(5)
+	cdx2, _ := NewCDXAPI("archive.org")
+	cdx2.SetSort(SortClosest, tm)
+	cdx2.SetLimit(1)
+	tests := []struct {
+		name string
+		cdx  *CDXAPI
+		want string
+	}{
+		{"Latest N", cdx1, cdxURL + "?fastLatest=true&limit=-5&url=archive.org"},
+		{"Closest", cdx2, cdxURL + "?closest=20060102150405&limit=1&sort=closest&url=archive.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.cdx.buildURL(&buf); err != nil || buf.String() != tt.want {
+				t.Errorf("CDXAPI.buildURL() = %v, %v, want %v", buf.String(), err, tt.want)
+			}
+			if tt.cdx.Limit() <= 0 {
+				t.Errorf("CDXAPI.buildURL() changed the limit to %v", tt.cdx.Limit())
+			}
+		})
+	}
+}
+
+func TestCDXAPI_SetPageSize(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		size    int
+		wantErr bool
+	}{
+		{"ErrorInvalidNumber", cdx, 0, true},
+		{"Valid size", cdx, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cdx.SetPageSize(tt.size); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.SetPageSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_PageSize(t *testing.T) {
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetPageSize(5)
+	tests := []struct {
+		name string
+		cdx  *CDXAPI
+		want int
+	}{
+		{"No page size", cdx1, -1},
+		{"Valid page size", cdx2, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cdx.PageSize(); got != tt.want {
+				t.Errorf("CDXAPI.PageSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_ResetPageSize(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetPageSize(5)
+	cdx.ResetPageSize()
+	if cdx.PageSize() != -1 {
+		t.Errorf("CDXAPI.ResetPageSize() didn't reset the page size")
+	}
+}
+
+func TestCDXAPI_NumPagesContext(t *testing.T) {
+	resumption, _ := NewCDXAPI("archive.org")
+	resumption.SetResumptionKey(true, "")
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		body    string
+		want    int
+		wantErr bool
+	}{
+		{"ErrorPaginationResumption", resumption, "3", 0, true},
+		{"Valid response", nil, "3\n", 3, false},
+		{"ErrorMalformedResult", nil, "abc", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("showNumPages") != "true" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			cdx := tt.cdx
+			if cdx == nil {
+				cdx, _ = NewCDXAPI("archive.org")
+			}
+			cdx.SetCDXEndpoint(srv.URL)
+			got, err := cdx.NumPagesContext(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.NumPagesContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CDXAPI.NumPagesContext() = %v, want %v", got, tt.want)
+			}
+			if cdx.params.Get("showNumPages") != "" {
+				t.Errorf("CDXAPI.NumPagesContext() did not reset showNumPages")
+			}
+		})
+	}
+}
+
 func TestCDXAPI_buildURL(t *testing.T) {
 	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
 	cdx1.SetURL("archive.org")
@@ -830,6 +1492,27 @@ func TestCDXAPI_buildURL(t *testing.T) {
 	}
 }
 
+func TestCDXAPI_buildURLEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{"Plain endpoint", "http://localhost/coll/cdx", "http://localhost/coll/cdx?url=archive.org"},
+		{"Endpoint with query", "http://localhost/cdx?coll=all", "http://localhost/cdx?coll=all&url=archive.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(tt.endpoint)
+			var buf bytes.Buffer
+			if err := cdx.buildURL(&buf); err != nil || buf.String() != tt.want {
+				t.Errorf("CDXAPI.buildURL() = %v, %v, want %v", buf.String(), err, tt.want)
+			}
+		})
+	}
+}
+
 func TestCDXRawQuery_Read(t *testing.T) {
 	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
 	cdx1.SetURL("archive.org")
@@ -889,6 +1572,57 @@ func TestCDXAPI_RawPerform(t *testing.T) {
 	}
 }
 
+func TestCDXAPI_RawPerformContext(t *testing.T) {
+	cdx1 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2 := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx2.SetURL("archive.org")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		ctx     context.Context
+		wantErr bool
+	}{
+		{"ErrorInvalidURL", cdx1, context.Background(), true},
+		{"Cancelled", cdx2, cancelled, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cdx.RawPerformContext(tt.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.RawPerformContext() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("CDXAPI.RawPerformContext() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestCDXResult_DataContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name    string
+		result  CDXResult
+		ctx     context.Context
+		wantErr bool
+	}{
+		{"Cancelled", CDXResult{Original: "archive.org", Timestamp: tm}, cancelled, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.result.DataContext(tt.ctx).Read(make([]byte, 10))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CDXResult.DataContext().Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_cdxResultReader_Read(t *testing.T) {
 	type args struct {
 		p []byte
@@ -916,6 +1650,63 @@ func Test_cdxResultReader_Read(t *testing.T) {
 	}
 }
 
+func TestCDXAPI_PerformContext(t *testing.T) {
+	cdx := &CDXAPI{params: &neturl.Values{}, urlBuf: &bytes.Buffer{}}
+	cdx.SetURL("archive.org")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		cdx     *CDXAPI
+		ctx     context.Context
+		wantErr bool
+	}{
+		{"Cancelled", cdx, cancelled, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cdx.PerformContext(tt.ctx); (err != nil) != tt.wantErr {
+				t.Errorf("CDXAPI.PerformContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_PerformTransport(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	target, _ := neturl.Parse(srv.URL)
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetTransport(&rewriteTransport{target: target})
+	results, err := cdx.Perform()
+	if err != nil {
+		t.Fatalf("CDXAPI.Perform() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Digest != "AAAA" || results[0].Length != 123 {
+		t.Fatalf("CDXAPI.Perform() = %v, want a single result", results)
+	}
+	data, err := ioutil.ReadAll(results[0].Data)
+	if err != nil || string(data) != "snapshot" {
+		t.Errorf("CDXResult.Data = %q, %v, want snapshot", data, err)
+	}
+}
+
+func TestCDXAPI_PerformEndpoints(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetCDXEndpoint(srv.URL + "/cdx/search/cdx")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	results, err := cdx.Perform()
+	if err != nil || len(results) != 1 {
+		t.Fatalf("CDXAPI.Perform() = %v, %v, want a single result", results, err)
+	}
+	data, err := ioutil.ReadAll(results[0].Data)
+	if err != nil || string(data) != "snapshot" {
+		t.Errorf("CDXResult.Data = %q, %v, want snapshot", data, err)
+	}
+}
+
 func TestCDXAPI_Perform(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -938,3 +1729,77 @@ func TestCDXAPI_Perform(t *testing.T) {
 		})
 	}
 }
+
+func TestCDXRawQuery_Close(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	tests := []struct {
+		name     string
+		readFull bool
+	}{
+		{"CloseUnread", false},
+		{"CloseAfterEOF", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx, _ := NewCDXAPI("archive.org")
+			cdx.SetCDXEndpoint(srv.URL + "/cdx/search/cdx")
+			qry, err := cdx.RawPerform()
+			if err != nil {
+				t.Fatalf("CDXAPI.RawPerform() error = %v", err)
+			}
+			if tt.readFull {
+				if _, err := ioutil.ReadAll(qry); err != nil {
+					t.Fatalf("CDXRawQuery.Read() error = %v", err)
+				}
+				if _, err := qry.Read(make([]byte, 10)); err != io.EOF {
+					t.Errorf("CDXRawQuery.Read() after EOF error = %v, want %v", err, io.EOF)
+				}
+			}
+			if err := qry.Close(); err != nil {
+				t.Errorf("CDXRawQuery.Close() error = %v", err)
+			}
+			if err := qry.Close(); err != nil {
+				t.Errorf("second CDXRawQuery.Close() error = %v", err)
+			}
+			if _, err := qry.Read(make([]byte, 10)); err != ErrorClosed {
+				t.Errorf("CDXRawQuery.Read() after Close error = %v, want %v", err, ErrorClosed)
+			}
+		})
+	}
+}
+
+func Test_cdxResultReader_Close(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	tests := []struct {
+		name string
+		read int
+	}{
+		{"CloseUnread", 0},
+		{"ClosePartial", 2},
+		{"CloseAfterEOF", 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dr := &cdxResultReader{cdx: cdx, ctx: context.Background(), url: cdx.snapshotURL(tm, "http://archive.org/", ReplayModeDefault)}
+			if tt.read > 0 {
+				if _, err := io.ReadFull(dr, make([]byte, tt.read)); err != nil && err != io.ErrUnexpectedEOF {
+					t.Fatalf("cdxResultReader.Read() error = %v", err)
+				}
+			}
+			if err := dr.Close(); err != nil {
+				t.Errorf("cdxResultReader.Close() error = %v", err)
+			}
+			if err := dr.Close(); err != nil {
+				t.Errorf("second cdxResultReader.Close() error = %v", err)
+			}
+			if _, err := dr.Read(make([]byte, 10)); err != ErrorClosed {
+				t.Errorf("cdxResultReader.Read() after Close error = %v, want %v", err, ErrorClosed)
+			}
+		})
+	}
+}
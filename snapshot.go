@@ -0,0 +1,180 @@
+package simplewayback
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// archivedHeaderPrefix prefixes the original headers of a capture in replay responses
+const archivedHeaderPrefix = "X-Archive-Orig-"
+
+// replayTimestamp matches the timestamp of a replay URL, e.g. /web/20060102150405id_/
+var replayTimestamp = regexp.MustCompile(`/(\d{14})(?:[a-z]{2}_)?/`)
+
+// Snapshot is the response of the archive to a snapshot request. Body must be closed by the
+// caller. Snapshots are only returned for successful responses; the target of a redirect that
+// is not followed is reported through *RedirectError instead.
+type Snapshot struct {
+	// URL is the URL the snapshot has been served from. It may differ from the requested
+	// URL if the archive redirected to another capture.
+	URL string
+	// StatusCode is the HTTP status code of the replay response
+	StatusCode int
+	// ContentType is the content type of the replay response
+	ContentType string
+	// Timestamp is the capture time of the served snapshot (Memento-Datetime). It may differ
+	// from the requested timestamp.
+	Timestamp time.Time
+	// Header holds all headers of the replay response
+	Header http.Header
+	// ArchivedHeader holds the headers of the original capture (X-Archive-Orig-*) with
+	// their prefix stripped
+	ArchivedHeader http.Header
+	// Body is the snapshot data
+	Body io.ReadCloser
+}
+
+// Close closes the body of the snapshot
+func (s *Snapshot) Close() error {
+	return s.Body.Close()
+}
+
+// Open requests the snapshot of r using the replay mode of the CDXAPI that returned r and
+// exposes the response metadata along with the snapshot data. As the metadata describes the
+// replay response, the snapshot is always requested from the replay endpoint, even if a WARC
+// directory is set. Redirects that are not followed, see SetFollowRedirects, are returned as
+// *RedirectError holding the redirect target.
+func (r CDXResult) Open() (*Snapshot, error) {
+	return r.OpenContext(context.Background())
+}
+
+// OpenContext is like Open, but the request is bound to ctx
+func (r CDXResult) OpenContext(ctx context.Context) (*Snapshot, error) {
+	return r.open(ctx, replayMode(r.cdx.ReplayMode()))
+}
+
+// open requests the snapshot of r using the given replay mode
+func (r CDXResult) open(ctx context.Context, mode replayMode) (*Snapshot, error) {
+	resp, err := r.cdx.fetchSnapshot(ctx, r.snapshotURL(mode), mode)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshot(resp), nil
+}
+
+// maxRedirects is the maximum number of archive-internal redirects followed by snapshot requests
+const maxRedirects = 10
+
+// SetFollowRedirects sets whether snapshot requests follow redirects within the replay endpoint,
+// e.g. to the capture actually stored for a requested timestamp. Redirects are never followed
+// outside of the replay endpoint. Unfollowed redirects are returned as *RedirectError.
+func (cdx *CDXAPI) SetFollowRedirects(enabled bool) error {
+	cdx.followRedirects = enabled
+	return nil
+}
+
+// FollowRedirects getter
+func (cdx *CDXAPI) FollowRedirects() bool {
+	return cdx != nil && cdx.followRedirects
+}
+
+// ResetFollowRedirects resets following redirects (default: false)
+func (cdx *CDXAPI) ResetFollowRedirects() {
+	cdx.followRedirects = false
+}
+
+// fetchSnapshot requests a snapshot from the archive. Redirects are followed according to
+// FollowRedirects, unfollowed redirects are returned as *RedirectError and other non-success
+// responses, including the error pages of the archive, as *APIError. It is safe to call on a
+// nil *CDXAPI.
+func (cdx *CDXAPI) fetchSnapshot(ctx context.Context, url string, mode replayMode) (*http.Response, error) {
+	// redirects are handled here, so every hop passes the rate limiter and retry policy
+	client := *cdx.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for redirects := 0; ; redirects++ {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ctx != nil {
+			req = req.WithContext(ctx)
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if mode == ReplayModeIdentity {
+			// keep the archived content encoding, so the payload stays byte-identical
+			req.Header.Set("Accept-Encoding", "identity")
+		}
+		req.Header.Set("Accept", "*/*")
+		resp, err := cdx.doClient(&client, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return resp, nil
+		}
+		location, err := resp.Location()
+		if resp.StatusCode < 300 || resp.StatusCode > 399 || err != nil {
+			return nil, newAPIError(resp)
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+		if !cdx.FollowRedirects() || !cdx.isReplayURL(location) || redirects >= maxRedirects {
+			redirect := &RedirectError{StatusCode: resp.StatusCode, URL: url, Location: location.String()}
+			if match := replayTimestamp.FindStringSubmatch(location.Path); match != nil {
+				redirect.Timestamp, _ = time.Parse("20060102150405", match[1])
+			}
+			return nil, redirect
+		}
+		url = location.String()
+	}
+}
+
+// isReplayURL reports whether u points into the replay endpoint. An upgrade from http to https
+// on the same host is considered to stay within the replay endpoint.
+func (cdx *CDXAPI) isReplayURL(u *neturl.URL) bool {
+	endpoint, err := neturl.Parse(cdx.ReplayEndpoint())
+	if err != nil || !strings.HasPrefix(u.Path, endpoint.Path+"/") {
+		return false
+	}
+	switch {
+	case strings.EqualFold(u.Scheme, endpoint.Scheme):
+		return strings.EqualFold(u.Host, endpoint.Host)
+	case strings.EqualFold(endpoint.Scheme, "http") && strings.EqualFold(u.Scheme, "https"):
+		// the default ports differ, so only the host names are compared
+		return strings.EqualFold(u.Hostname(), endpoint.Hostname())
+	}
+	return false
+}
+
+// newSnapshot extracts the snapshot metadata from a replay response
+func newSnapshot(resp *http.Response) *Snapshot {
+	snap := &Snapshot{
+		URL:            resp.Request.URL.String(),
+		StatusCode:     resp.StatusCode,
+		ContentType:    resp.Header.Get("Content-Type"),
+		Header:         resp.Header,
+		ArchivedHeader: http.Header{},
+		Body:           resp.Body,
+	}
+	for key, values := range resp.Header {
+		if len(key) > len(archivedHeaderPrefix) && strings.EqualFold(key[:len(archivedHeaderPrefix)], archivedHeaderPrefix) {
+			for _, value := range values {
+				snap.ArchivedHeader.Add(key[len(archivedHeaderPrefix):], value)
+			}
+		}
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Memento-Datetime")); err == nil {
+		snap.Timestamp = t
+	} else if match := replayTimestamp.FindStringSubmatch(resp.Request.URL.Path); match != nil {
+		snap.Timestamp, _ = time.Parse("20060102150405", match[1])
+	}
+	return snap
+}
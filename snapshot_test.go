@@ -0,0 +1,161 @@
+package simplewayback
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+	"time"
+)
+
+func TestCDXResult_OpenContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/web/20060102150405/http://archive.org/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Memento-Datetime", "Mon, 02 Jan 2006 15:04:07 GMT")
+			w.Header().Set("X-Archive-Orig-Server", "Apache")
+			w.Header().Add("X-Archive-Orig-Set-Cookie", "a=1")
+			w.Header().Add("X-Archive-Orig-Set-Cookie", "b=2")
+			w.Write([]byte("snapshot"))
+		case "/web/20070102150405/http://archive.org/":
+			w.Header().Set("Location", "/web/20080102150405/http://archive.org/")
+			w.WriteHeader(http.StatusFound)
+		case "/web/20080102150405/http://archive.org/":
+			w.Write([]byte("redirected"))
+		case "/web/20100102150405/http://archive.org/":
+			w.Header().Set("Location", "http://example.org/")
+			w.WriteHeader(http.StatusFound)
+		case "/web/20110102150405/http://archive.org/":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("<html><head><title>Too Many Requests</title></head></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	tests := []struct {
+		name          string
+		follow        bool
+		timestamp     string
+		wantTimestamp string
+		wantURL       string
+		wantBody      string
+		wantServer    string
+		wantCookies   int
+		wantErr       error
+	}{
+		{"Archived headers", false, "20060102150405", "20060102150407", "20060102150405", "snapshot", "Apache", 2, nil},
+		{"Redirected", true, "20070102150405", "20080102150405", "20080102150405", "redirected", "", 0, nil},
+		{"ErrorRedirect", false, "20070102150405", "", "", "", "", 0, ErrorRedirect},
+		{"ErrorRedirect external", true, "20100102150405", "", "", "", "", 0, ErrorRedirect},
+		{"ErrorNotFound", false, "20090102150405", "", "", "", "", 0, ErrorNotFound},
+		{"ErrorRateLimited", false, "20110102150405", "", "", "", "", 0, ErrorRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx.SetFollowRedirects(tt.follow)
+			tm, _ := time.Parse("20060102150405", tt.timestamp)
+			snap, err := CDXResult{Original: "http://archive.org/", Timestamp: tm, cdx: cdx}.OpenContext(context.Background())
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("CDXResult.OpenContext() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer snap.Close()
+			body, err := ioutil.ReadAll(snap.Body)
+			if err != nil || string(body) != tt.wantBody {
+				t.Errorf("Snapshot.Body = %q, %v, want %q", body, err, tt.wantBody)
+			}
+			if got := snap.Timestamp.Format("20060102150405"); got != tt.wantTimestamp {
+				t.Errorf("Snapshot.Timestamp = %v, want %v", got, tt.wantTimestamp)
+			}
+			if snap.StatusCode != http.StatusOK || snap.URL != srv.URL+"/web/"+tt.wantURL+"/http://archive.org/" {
+				t.Errorf("Snapshot = %d %v", snap.StatusCode, snap.URL)
+			}
+			if snap.ArchivedHeader.Get("Server") != tt.wantServer || len(snap.ArchivedHeader["Set-Cookie"]) != tt.wantCookies {
+				t.Errorf("Snapshot.ArchivedHeader = %v", snap.ArchivedHeader)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_fetchSnapshotRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/web/20070102150405/http://archive.org/":
+			w.Header().Set("Location", "/web/20080102150405/http://archive.org/")
+			w.WriteHeader(http.StatusFound)
+		case "/web/20090102150405/http://archive.org/":
+			w.Header().Set("Location", "/web/20090102150405/http://archive.org/")
+			w.WriteHeader(http.StatusFound)
+		case "/web/20100102150405/http://archive.org/":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<html><head><title>Wayback Machine</title></head><body>Hrm. Wayback Machine has not archived that URL.</body></html>"))
+		}
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	tests := []struct {
+		name          string
+		follow        bool
+		timestamp     string
+		wantTimestamp string
+		wantErr       error
+	}{
+		{"Redirect", false, "20070102150405", "20080102150405", ErrorRedirect},
+		{"Redirect loop", true, "20090102150405", "20090102150405", ErrorRedirect},
+		{"Error page", false, "20100102150405", "", ErrorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cdx.SetFollowRedirects(tt.follow)
+			tm, _ := time.Parse("20060102150405", tt.timestamp)
+			_, err := ioutil.ReadAll(CDXResult{Original: "http://archive.org/", Timestamp: tm, cdx: cdx}.DataContext(context.Background()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CDXResult.DataContext().Read() error = %v, want %v", err, tt.wantErr)
+			}
+			var redirect *RedirectError
+			if errors.As(err, &redirect) && redirect.Timestamp.Format("20060102150405") != tt.wantTimestamp {
+				t.Errorf("RedirectError.Timestamp = %v, want %v", redirect.Timestamp, tt.wantTimestamp)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_isReplayURL(t *testing.T) {
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint("http://web.archive.org/web")
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"Same scheme", "http://web.archive.org/web/20060102150405/http://archive.org/", true},
+		{"Upgrade to https", "https://web.archive.org/web/20060102150405/http://archive.org/", true},
+		{"Upgrade to https with default port", "https://WEB.archive.org:443/web/20060102150405/http://archive.org/", true},
+		{"Other path", "https://web.archive.org/save/http://archive.org/", false},
+		{"Other host", "https://example.org/web/20060102150405/http://archive.org/", false},
+		{"Other scheme", "ftp://web.archive.org/web/20060102150405/http://archive.org/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := neturl.Parse(tt.url)
+			if got := cdx.isReplayURL(u); got != tt.want {
+				t.Errorf("CDXAPI.isReplayURL(%v) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+	cdx.SetReplayEndpoint("https://web.archive.org/web")
+	u, _ := neturl.Parse("http://web.archive.org/web/20060102150405/http://archive.org/")
+	if cdx.isReplayURL(u) {
+		t.Errorf("CDXAPI.isReplayURL() accepted a downgrade to http")
+	}
+}
@@ -0,0 +1,248 @@
+package simplewayback
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// warcVersion is the version of the records written by WARCWriter
+const warcVersion = "WARC/1.1"
+
+// warcField is a named field of a WARC record header
+type warcField struct {
+	name, value string
+}
+
+// WARCWriter writes snapshots as WARC 1.1 records (ISO 28500:2017), preserving the provenance of
+// the archived data. Each snapshot is written as a response record, optionally followed by a
+// metadata record. No request records are written, as the original request is not archived
+// and the request sent to the replay endpoint does not belong to the archived response.
+type WARCWriter struct {
+	w        io.Writer
+	compress bool
+	metadata bool
+}
+
+// NewWARCWriter creates a WARC writer writing to w. If compress is true, every record is
+// compressed as a separate gzip member, as expected by WARC readers and CDX indexers.
+func NewWARCWriter(w io.Writer, compress bool) *WARCWriter {
+	return &WARCWriter{w: w, compress: compress}
+}
+
+// SetMetadataRecords sets whether a metadata record, holding the replay URL the snapshot has
+// been fetched from, is written along with each response record
+func (ww *WARCWriter) SetMetadataRecords(enabled bool) error {
+	ww.metadata = enabled
+	return nil
+}
+
+// MetadataRecords getter
+func (ww *WARCWriter) MetadataRecords() bool {
+	return ww.metadata
+}
+
+// WriteResult fetches the raw archived payload of r (ReplayModeIdentity) and writes it as WARC
+// records. Like CDXResult.Open, it always requests the replay endpoint, even if a WARC
+// directory is set.
+func (ww *WARCWriter) WriteResult(r CDXResult) error {
+	return ww.WriteResultContext(context.Background(), r)
+}
+
+// WriteResultContext is like WriteResult, but the request is bound to ctx
+func (ww *WARCWriter) WriteResultContext(ctx context.Context, r CDXResult) error {
+	snap, err := r.open(ctx, ReplayModeIdentity)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	return ww.WriteSnapshot(r, snap)
+}
+
+// WriteSnapshot writes snap, the snapshot of r, as WARC records. The body of snap is read to EOF,
+// but not closed. snap should be opened using ReplayModeIdentity, otherwise the records hold
+// the replay page instead of the archived payload.
+//
+// The response record is dated with r.Timestamp and holds the archived headers of snap. As the
+// payload has already been decoded from its transfer encoding, Transfer-Encoding is dropped
+// and Content-Length is set to the length of the payload.
+//
+// As the digests precede the record block, the payload is spooled to a temporary file while
+// they are computed. If the replay response lacks a Content-Length, the length of the payload
+// is only known once it has been spooled, so the block digest is computed in a second pass.
+func (ww *WARCWriter) WriteSnapshot(r CDXResult, snap *Snapshot) error {
+	date := r.Timestamp
+	if date.IsZero() {
+		date = snap.Timestamp
+	}
+	statusCode := r.StatusCode
+	if statusCode == 0 {
+		statusCode = snap.StatusCode
+	}
+	header := snap.ArchivedHeader.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if len(header) == 0 && snap.ContentType != "" {
+		header.Set("Content-Type", snap.ContentType)
+	}
+	header.Del("Transfer-Encoding")
+	length := int64(-1)
+	if n, err := strconv.ParseInt(snap.Header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+		length = n
+	}
+	spool, err := ioutil.TempFile("", "simplewayback")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	payloadHash, blockHash := sha1.New(), sha1.New()
+	var httpHeader []byte
+	w := io.MultiWriter(spool, payloadHash)
+	if length >= 0 {
+		httpHeader = responseHeader(statusCode, header, length)
+		blockHash.Write(httpHeader)
+		w = io.MultiWriter(spool, payloadHash, blockHash)
+	}
+	n, err := io.Copy(w, snap.Body)
+	if err != nil {
+		return err
+	}
+	if length >= 0 && n != length {
+		return io.ErrUnexpectedEOF
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if length < 0 {
+		length = n
+		httpHeader = responseHeader(statusCode, header, length)
+		blockHash.Write(httpHeader)
+		if _, err := io.Copy(blockHash, spool); err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	responseID := newRecordID()
+	warcDate := date.UTC().Format(time.RFC3339)
+	if err := ww.writeRecordFrom([]warcField{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", warcDate},
+		{"WARC-Target-URI", r.Original},
+		{"WARC-Payload-Digest", hashDigest(payloadHash)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, io.MultiReader(bytes.NewReader(httpHeader), spool), int64(len(httpHeader))+length, hashDigest(blockHash)); err != nil {
+		return err
+	}
+	if ww.metadata {
+		metadata := "via: " + snap.URL + "\r\n"
+		if r.Digest != "" && r.Digest != "-" {
+			metadata += "cdx-digest: sha1:" + normalizeDigest(r.Digest) + "\r\n"
+		}
+		if err := ww.writeRecord([]warcField{
+			{"WARC-Type", "metadata"},
+			{"WARC-Record-ID", newRecordID()},
+			{"WARC-Date", warcDate},
+			{"WARC-Target-URI", r.Original},
+			{"WARC-Refers-To", responseID},
+			{"Content-Type", "application/warc-fields"},
+		}, []byte(metadata)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// responseHeader returns the status line and header of the HTTP response block of a response
+// record with a payload of the given length
+func responseHeader(statusCode int, header http.Header, length int64) []byte {
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(&block, "%s: %s\r\n", key, value)
+		}
+	}
+	block.WriteString("\r\n")
+	return block.Bytes()
+}
+
+// writeRecord writes a single record consisting of the given header fields and block. The
+// block digest and content length are added to the header.
+func (ww *WARCWriter) writeRecord(fields []warcField, block []byte) error {
+	return ww.writeRecordFrom(fields, bytes.NewReader(block), int64(len(block)), warcDigest(block))
+}
+
+// writeRecordFrom is like writeRecord, but streams the block of the given length and digest
+// from block
+func (ww *WARCWriter) writeRecordFrom(fields []warcField, block io.Reader, length int64, digest string) error {
+	var gz *gzip.Writer
+	w := ww.w
+	if ww.compress {
+		gz = gzip.NewWriter(ww.w)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(warcVersion + "\r\n")
+	for _, field := range fields {
+		fmt.Fprintf(bw, "%s: %s\r\n", field.name, field.value)
+	}
+	fmt.Fprintf(bw, "WARC-Block-Digest: %s\r\n", digest)
+	fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", length)
+	if n, err := io.Copy(bw, block); err != nil {
+		return err
+	} else if n != length {
+		return io.ErrUnexpectedEOF
+	}
+	bw.WriteString("\r\n\r\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+// warcDigest returns the labelled, base32 encoded SHA-1 digest of data
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// hashDigest returns the labelled, base32 encoded digest of the data written to the SHA-1 hash h
+func hashDigest(h hash.Hash) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// newRecordID returns a random (version 4) UUID URN enclosed in angle brackets
+func newRecordID() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
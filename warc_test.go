@@ -0,0 +1,134 @@
+package simplewayback
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_newRecordID(t *testing.T) {
+	pattern := regexp.MustCompile(`^<urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}>$`)
+	if id := newRecordID(); !pattern.MatchString(id) || id == newRecordID() {
+		t.Errorf("newRecordID() = %v", id)
+	}
+}
+
+func TestWARCWriter_WriteResult(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/web/20060102150405id_/http://archive.org/":
+			w.Header().Set("X-Archive-Orig-Server", "Apache")
+			w.Header().Set("X-Archive-Orig-Transfer-Encoding", "chunked")
+			w.Header().Set("X-Archive-Orig-Content-Type", "text/html")
+			w.Write([]byte("snapshot"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cdx, _ := NewCDXAPI("archive.org")
+	cdx.SetReplayEndpoint(srv.URL + "/web")
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	result := CDXResult{Original: "http://archive.org/", Timestamp: tm, StatusCode: 200, Digest: testDigest("snapshot"), cdx: cdx}
+	tests := []struct {
+		name        string
+		compress    bool
+		metadata    bool
+		wantRecords int
+	}{
+		{"Response", false, false, 1},
+		{"Compressed with metadata", true, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ww := NewWARCWriter(&buf, tt.compress)
+			ww.SetMetadataRecords(tt.metadata)
+			if err := ww.WriteResult(result); err != nil {
+				t.Fatalf("WARCWriter.WriteResult() error = %v", err)
+			}
+			var r io.Reader = &buf
+			if tt.compress {
+				gz, err := gzip.NewReader(&buf)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				r = gz
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll() error = %v", err)
+			}
+			warc := string(data)
+			if got := strings.Count(warc, "WARC/1.1\r\n"); got != tt.wantRecords {
+				t.Errorf("WARCWriter wrote %d records, want %d", got, tt.wantRecords)
+			}
+			for _, want := range []string{
+				"WARC-Type: response\r\n",
+				"WARC-Date: 2006-01-02T15:04:05Z\r\n",
+				"WARC-Target-URI: http://archive.org/\r\n",
+				"WARC-Payload-Digest: sha1:" + testDigest("snapshot") + "\r\n",
+				"\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 8\r\nContent-Type: text/html\r\nServer: Apache\r\n\r\nsnapshot\r\n\r\n",
+			} {
+				if !strings.Contains(warc, want) {
+					t.Errorf("WARCWriter record misses %q:\n%s", want, warc)
+				}
+			}
+			if strings.Contains(warc, "WARC-Type: request\r\n") {
+				t.Errorf("WARCWriter wrote a request record:\n%s", warc)
+			}
+			if tt.metadata && !strings.Contains(warc, "via: "+srv.URL+"/web/20060102150405id_/http://archive.org/\r\n") {
+				t.Errorf("WARCWriter metadata record missing:\n%s", warc)
+			}
+		})
+	}
+}
+
+func TestWARCWriter_WriteSnapshot(t *testing.T) {
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	result := CDXResult{Original: "http://archive.org/", Timestamp: tm, StatusCode: 200}
+	block := "HTTP/1.1 200 OK\r\nContent-Length: 8\r\nContent-Type: text/html\r\n\r\nsnapshot"
+	tests := []struct {
+		name    string
+		header  http.Header
+		wantErr error
+	}{
+		{"Content-Length", http.Header{"Content-Length": {"8"}}, nil},
+		{"Missing Content-Length", nil, nil},
+		{"Truncated", http.Header{"Content-Length": {"9"}}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			snap := &Snapshot{
+				StatusCode:     http.StatusOK,
+				Header:         tt.header,
+				ArchivedHeader: http.Header{"Content-Type": {"text/html"}},
+				Body:           ioutil.NopCloser(strings.NewReader("snapshot")),
+			}
+			if err := NewWARCWriter(&buf, false).WriteSnapshot(result, snap); err != tt.wantErr {
+				t.Fatalf("WARCWriter.WriteSnapshot() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			for _, want := range []string{
+				"WARC-Payload-Digest: sha1:" + testDigest("snapshot") + "\r\n",
+				"WARC-Block-Digest: " + warcDigest([]byte(block)) + "\r\n",
+				"Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n" + block + "\r\n\r\n",
+			} {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("WARCWriter record misses %q:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
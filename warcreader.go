@@ -0,0 +1,242 @@
+package simplewayback
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WARCRecord is a single record of a WARC file
+type WARCRecord struct {
+	// Header holds the WARC header fields of the record
+	Header http.Header
+	// Type is the record type, e.g. "response" (WARC-Type)
+	Type string
+	// RecordID is the globally unique ID of the record (WARC-Record-ID)
+	RecordID string
+	// TargetURI is the URI of the captured resource (WARC-Target-URI)
+	TargetURI string
+	// Date is the capture time (WARC-Date)
+	Date time.Time
+	// ContentLength is the length of the record block
+	ContentLength int64
+	// Body is the record block. It is only valid until the next call of WARCReader.Next.
+	Body io.Reader
+}
+
+// HTTPResponse parses the block of a response or revisit record holding an HTTP response
+// (application/http). The body of the returned response is the payload of the record.
+func (rec *WARCRecord) HTTPResponse() (*http.Response, error) {
+	mediaType, _, _ := mime.ParseMediaType(rec.Header.Get("Content-Type"))
+	if mediaType != "application/http" {
+		return nil, ErrorMalformedWARC
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(rec.Body), nil)
+	if err != nil {
+		return nil, ErrorMalformedWARC
+	}
+	return resp, nil
+}
+
+// Payload returns the payload of the record: the body of the HTTP response for response
+// records holding an HTTP response, the record block otherwise
+func (rec *WARCRecord) Payload() (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(rec.Header.Get("Content-Type"))
+	if rec.Type != "response" || mediaType != "application/http" {
+		return rec.Body, nil
+	}
+	resp, err := rec.HTTPResponse()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// WARCReader iterates the records of a WARC file. Both uncompressed and gzip compressed WARC
+// files are supported.
+//
+//	wr, err := NewWARCReader(file)
+//	...
+//	for wr.Next() {
+//		record := wr.Record()
+//		...
+//	}
+//	if err := wr.Err(); err != nil {
+//		...
+//	}
+type WARCReader struct {
+	br     *bufio.Reader
+	record *WARCRecord
+	block  io.Reader
+	err    error
+	done   bool
+}
+
+// NewWARCReader creates a reader for the WARC records read from r
+func NewWARCReader(r io.Reader) (*WARCReader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+	return &WARCReader{br: br}, nil
+}
+
+// Next advances to the next record, which is then available through Record. It returns false
+// when all records have been read or an error occurred.
+func (wr *WARCReader) Next() bool {
+	if wr.done {
+		return false
+	}
+	if wr.block != nil {
+		// skip the unread part of the previous record
+		if _, err := io.Copy(ioutil.Discard, wr.block); err != nil {
+			return wr.finish(err)
+		}
+		wr.record, wr.block = nil, nil
+	}
+	// records are separated by blank lines
+	var line string
+	for line == "" {
+		l, err := wr.br.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return wr.finish(nil)
+		}
+		if err != nil {
+			return wr.finish(ErrorMalformedWARC)
+		}
+		line = strings.TrimRight(l, "\r\n")
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return wr.finish(ErrorMalformedWARC)
+	}
+	mimeHeader, err := textproto.NewReader(wr.br).ReadMIMEHeader()
+	if err != nil {
+		return wr.finish(ErrorMalformedWARC)
+	}
+	header := http.Header(mimeHeader)
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return wr.finish(ErrorMalformedWARC)
+	}
+	wr.block = io.LimitReader(wr.br, length)
+	wr.record = &WARCRecord{
+		Header:        header,
+		Type:          header.Get("WARC-Type"),
+		RecordID:      header.Get("WARC-Record-ID"),
+		TargetURI:     strings.Trim(header.Get("WARC-Target-URI"), "<>"),
+		ContentLength: length,
+		Body:          wr.block,
+	}
+	wr.record.Date, _ = time.Parse(time.RFC3339, header.Get("WARC-Date"))
+	return true
+}
+
+// Record returns the current record
+func (wr *WARCReader) Record() *WARCRecord {
+	return wr.record
+}
+
+// Err returns the first error that occurred while reading the records
+func (wr *WARCReader) Err() error {
+	return wr.err
+}
+
+// finish stops the iteration with err
+func (wr *WARCReader) finish(err error) bool {
+	wr.err = err
+	wr.done = true
+	wr.record, wr.block = nil, nil
+	return false
+}
+
+// warcPayload is the payload of a record read from a WARC file
+type warcPayload struct {
+	io.Reader
+	file *os.File
+}
+
+// Close closes the WARC file
+func (p *warcPayload) Close() error {
+	return p.file.Close()
+}
+
+// OpenWARCPayload opens the record at offset in the WARC file filename and returns its payload,
+// e.g. using the Filename, Offset and Length of a CDXResult. length is the (compressed) length
+// of the record; if it is unknown, pass 0. The returned reader must be closed by the caller.
+func OpenWARCPayload(filename string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := openWARCPayload(file, offset, length)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &warcPayload{Reader: payload, file: file}, nil
+}
+
+// openWARCPayload reads the payload of the record at offset in file
+func openWARCPayload(file *os.File, offset, length int64) (io.Reader, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var r io.Reader = file
+	if length > 0 {
+		r = io.LimitReader(file, length)
+	}
+	wr, err := NewWARCReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if !wr.Next() {
+		if wr.Err() != nil {
+			return nil, wr.Err()
+		}
+		return nil, ErrorMalformedWARC
+	}
+	return wr.Record().Payload()
+}
+
+// SetWARCDir sets a directory of local WARC files. If set, the Data of results holding a
+// filename, offset and length, e.g. from a local CDX server, is read from the WARC files in
+// dir instead of being requested from the replay endpoint. This applies to Data, DataContext
+// and VerifiedData only; Open and WARCWriter.WriteResult expose the replay response and
+// therefore always request the replay endpoint. Filenames resolving to a path outside of dir
+// are rejected with ErrorInvalidWARCFilename.
+func (cdx *CDXAPI) SetWARCDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return ErrorInvalidWARCDir
+	}
+	cdx.warcDir = dir
+	return nil
+}
+
+// WARCDir getter
+func (cdx *CDXAPI) WARCDir() string {
+	if cdx == nil {
+		return ""
+	}
+	return cdx.warcDir
+}
+
+// ResetWARCDir resets the WARC directory (default: none)
+func (cdx *CDXAPI) ResetWARCDir() {
+	cdx.warcDir = ""
+}
@@ -0,0 +1,177 @@
+package simplewayback
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestWARC writes a response record for every payload to dir/name and returns the offsets
+// and lengths of the records
+func writeTestWARC(t *testing.T, dir, name string, compress bool, payloads ...string) ([]int64, []int) {
+	var buf bytes.Buffer
+	ww := NewWARCWriter(&buf, compress)
+	ww.SetMetadataRecords(true)
+	tm, _ := time.Parse("20060102150405", "20060102150405")
+	var offsets []int64
+	var lengths []int
+	for i, payload := range payloads {
+		offset := buf.Len()
+		snap := &Snapshot{
+			URL:            "http://web.archive.org/web/20060102150405id_/http://archive.org/",
+			StatusCode:     http.StatusOK,
+			ArchivedHeader: http.Header{"Content-Type": {"text/plain"}},
+			Body:           ioutil.NopCloser(strings.NewReader(payload)),
+		}
+		result := CDXResult{Original: "http://archive.org/" + strings.Repeat("a", i), Timestamp: tm}
+		if err := ww.WriteSnapshot(result, snap); err != nil {
+			t.Fatalf("WARCWriter.WriteSnapshot() error = %v", err)
+		}
+		offsets = append(offsets, int64(offset))
+		lengths = append(lengths, buf.Len()-offset)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return offsets, lengths
+}
+
+func TestWARCReader_Next(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplewayback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestWARC(t, dir, "test.warc", false, "first", "second")
+	writeTestWARC(t, dir, "test.warc.gz", true, "first", "second")
+	tests := []struct {
+		name         string
+		data         string
+		wantTypes    []string
+		wantPayloads []string
+		wantErr      error
+	}{
+		{"Uncompressed", "test.warc", []string{"response", "metadata", "response", "metadata"}, []string{"first", "via: http://web.archive.org/web/20060102150405id_/http://archive.org/\r\n", "second", "via: http://web.archive.org/web/20060102150405id_/http://archive.org/\r\n"}, nil},
+		{"Compressed", "test.warc.gz", []string{"response", "metadata", "response", "metadata"}, []string{"first", "via: http://web.archive.org/web/20060102150405id_/http://archive.org/\r\n", "second", "via: http://web.archive.org/web/20060102150405id_/http://archive.org/\r\n"}, nil},
+		{"Empty", "", nil, nil, nil},
+		{"ErrorMalformedWARC", "HTTP/1.1 200 OK\r\n\r\n", nil, nil, ErrorMalformedWARC},
+		{"ErrorMalformedWARC length", "WARC/1.1\r\nWARC-Type: response\r\n\r\n", nil, nil, ErrorMalformedWARC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(tt.data)
+			if strings.HasPrefix(tt.data, "test.warc") {
+				data, _ = ioutil.ReadFile(filepath.Join(dir, tt.data))
+			}
+			wr, err := NewWARCReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("NewWARCReader() error = %v", err)
+			}
+			var types, payloads []string
+			for wr.Next() {
+				record := wr.Record()
+				if record.TargetURI == "" || record.Date.Format("20060102150405") != "20060102150405" {
+					t.Errorf("WARCReader.Record() = %+v", record)
+				}
+				payload, err := record.Payload()
+				if err != nil {
+					t.Fatalf("WARCRecord.Payload() error = %v", err)
+				}
+				body, _ := ioutil.ReadAll(payload)
+				types = append(types, record.Type)
+				payloads = append(payloads, string(body))
+			}
+			if !errors.Is(wr.Err(), tt.wantErr) || (tt.wantErr == nil && wr.Err() != nil) {
+				t.Errorf("WARCReader.Err() = %v, want %v", wr.Err(), tt.wantErr)
+			}
+			if strings.Join(types, ",") != strings.Join(tt.wantTypes, ",") || strings.Join(payloads, ",") != strings.Join(tt.wantPayloads, ",") {
+				t.Errorf("WARCReader records = %q %q, want %q %q", types, payloads, tt.wantTypes, tt.wantPayloads)
+			}
+		})
+	}
+}
+
+func TestOpenWARCPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplewayback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	offsets, lengths := writeTestWARC(t, dir, "test.warc.gz", true, "first", "second")
+	tests := []struct {
+		name     string
+		filename string
+		offset   int64
+		length   int64
+		want     string
+		wantErr  bool
+	}{
+		{"First record", "test.warc.gz", offsets[0], int64(lengths[0]), "first", false},
+		{"Second record", "test.warc.gz", offsets[1], int64(lengths[1]), "second", false},
+		{"Unknown length", "test.warc.gz", offsets[1], 0, "second", false},
+		{"Missing file", "missing.warc.gz", 0, 0, "", true},
+		{"Invalid offset", "test.warc.gz", offsets[1] + 1, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := OpenWARCPayload(filepath.Join(dir, tt.filename), tt.offset, tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenWARCPayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer payload.Close()
+			if got, err := ioutil.ReadAll(payload); err != nil || string(got) != tt.want {
+				t.Errorf("OpenWARCPayload() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCDXAPI_SetWARCDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplewayback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	offsets, lengths := writeTestWARC(t, dir, "test.warc.gz", true, "first", "second")
+	cdx, _ := NewCDXAPI("archive.org")
+	if err := cdx.SetWARCDir(filepath.Join(dir, "test.warc.gz")); err != ErrorInvalidWARCDir {
+		t.Errorf("CDXAPI.SetWARCDir() error = %v, want %v", err, ErrorInvalidWARCDir)
+	}
+	if err := cdx.SetWARCDir(dir); err != nil || cdx.WARCDir() != dir {
+		t.Fatalf("CDXAPI.SetWARCDir() error = %v, WARCDir() = %v", err, cdx.WARCDir())
+	}
+	line := "org,archive)/ 20060102150405 http://archive.org/ text/plain 200 " + testDigest("second") + " " +
+		strconv.Itoa(lengths[1]) + " " + strconv.FormatInt(offsets[1], 10) + " test.warc.gz"
+	result, err := ParseCDXLine(line, FieldURLKey, FieldTimestamp, FieldOriginal, FieldMimetype, FieldStatuscode, FieldDigest, FieldLength, FieldOffset, FieldFilename)
+	if err != nil {
+		t.Fatalf("ParseCDXLine() error = %v", err)
+	}
+	result.cdx = cdx
+	for _, data := range []io.Reader{result.DataContext(context.Background()), result.VerifiedData(context.Background())} {
+		if got, err := ioutil.ReadAll(data); err != nil || string(got) != "second" {
+			t.Errorf("CDXResult data = %q, %v, want second", got, err)
+		}
+	}
+	for _, filename := range []string{"../test.warc.gz", "sub/../../test.warc.gz", "../" + filepath.Base(dir) + "x/test.warc.gz"} {
+		result.Filename = filename
+		if _, err := ioutil.ReadAll(result.DataContext(context.Background())); err != ErrorInvalidWARCFilename {
+			t.Errorf("CDXResult data of %v error = %v, want %v", filename, err, ErrorInvalidWARCFilename)
+		}
+	}
+	cdx.ResetWARCDir()
+	if cdx.WARCDir() != "" {
+		t.Errorf("CDXAPI.ResetWARCDir() = %v, want empty", cdx.WARCDir())
+	}
+}